@@ -0,0 +1,80 @@
+package main
+
+// header-updater copies objects of images in place with Cache-Control, Content-Disposition and metadata
+// of their transformations, it should be run after headers in ensure-transforms.json or CACHE_CONTROL are changed
+
+import (
+	"github.com/KazanExpress/louis/internal/app/louis"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+
+	var err error
+	var appCtx = new(louis.AppContext)
+	appCtx.Config = utils.InitConfig()
+	appCtx.DB, err = storage.Open(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Storage, err = storage.InitS3Context(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Encryption, err = storage.NewKeyring(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var service = louis.NewLouisService(appCtx)
+	appCtx.ImageService = service
+
+	var batchSize = 100
+
+	if len(os.Args) > 1 {
+		var batch, err = strconv.Atoi(os.Args[1])
+		if err != nil {
+			log.Printf("failed to parse batch size argument. ignoring it")
+		} else {
+			batchSize = batch
+		}
+	}
+
+	var lastID int64
+	var processed, updated, failed int
+	for {
+		var res = new([]storage.Image)
+		var err = appCtx.DB.
+			Where("id > ? and url <> ''", lastID).
+			Order("id").
+			Limit(batchSize).
+			Find(res).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for i := range *res {
+			var img = &(*res)[i]
+			count, err := service.UpdateHeaders(img)
+			processed++
+			updated += count
+			if err != nil {
+				failed++
+				log.Printf("failed to update headers of %v - %s", img.Key, err)
+			}
+			lastID = img.ID
+		}
+
+		if len(*res) < batchSize {
+			break
+		}
+	}
+
+	log.Printf("from %v images %v failed, headers of %v objects updated", processed, failed, updated)
+}
@@ -0,0 +1,89 @@
+package main
+
+// key-rotator re-encrypts "real" copies of images and their replicas with current SSE settings,
+// it should be run after SSE mode or key is changed, rotated customer keys should be kept
+// in SSE_PREVIOUS_CUSTOMER_KEYS until it finishes
+
+import (
+	"github.com/KazanExpress/louis/internal/app/louis"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+
+	var err error
+	var appCtx = new(louis.AppContext)
+	appCtx.Config = utils.InitConfig()
+	appCtx.DB, err = storage.Open(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Storage, err = storage.InitS3Context(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Encryption, err = storage.NewKeyring(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Replica, err = storage.InitReplica(appCtx.Config, appCtx.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var service = louis.NewLouisService(appCtx)
+	appCtx.ImageService = service
+
+	var batchSize = 100
+
+	if len(os.Args) > 1 {
+		var batch, err = strconv.Atoi(os.Args[1])
+		if err != nil {
+			log.Printf("failed to parse batch size argument. ignoring it")
+		} else {
+			batchSize = batch
+		}
+	}
+
+	var keyID = appCtx.Encryption.CurrentKeyID()
+	var lastID int64
+	var processed, reencrypted, failed int
+	for {
+		var res = new([]storage.Image)
+		var err = appCtx.DB.
+			Where("id > ? and with_real_copy = true and transforms_uploaded = true", lastID).
+			Where("real_encryption_key <> ? or (replica_status = ? and replica_encryption_key <> ?)", keyID, storage.ReplicaDone, keyID).
+			Order("id").
+			Limit(batchSize).
+			Find(res).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for i := range *res {
+			var img = &(*res)[i]
+			changed, err := service.Reencrypt(img)
+			processed++
+			if err != nil {
+				failed++
+				log.Printf("failed to re-encrypt %v - %s", img.Key, err)
+			} else if changed {
+				reencrypted++
+			}
+			lastID = img.ID
+		}
+
+		if len(*res) < batchSize {
+			break
+		}
+	}
+
+	log.Printf("from %v images %v re-encrypted, %v failed", processed, reencrypted, failed)
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/KazanExpress/louis/internal/app/louis"
+	"github.com/KazanExpress/louis/internal/pkg/keylayout"
+	"github.com/KazanExpress/louis/internal/pkg/publicurl"
 	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/transformations"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/prometheus/client_golang/prometheus"
@@ -47,6 +50,26 @@ func getLouisAppContext() *louis.AppContext {
 		log.Fatal(err)
 	}
 
+	appCtx.Replica, err = storage.InitReplica(appCtx.Config, appCtx.Storage)
+	if err != nil {
+		log.Fatalf("FATAL: invalid replica settings - %v", err)
+	}
+
+	appCtx.Encryption, err = storage.NewKeyring(appCtx.Config)
+	if err != nil {
+		log.Fatalf("FATAL: invalid encryption settings - %v", err)
+	}
+
+	appCtx.KeyLayout, err = keylayout.New(appCtx.Config.KeyLayout)
+	if err != nil {
+		log.Fatalf("FATAL: invalid key layout - %v", err)
+	}
+
+	appCtx.PublicURL, err = publicurl.New(appCtx.Config.PublicURLTemplate, appCtx.Config.CDNBaseURL, appCtx.Config.CDNTagHosts)
+	if err != nil {
+		log.Fatalf("FATAL: invalid public url template - %v", err)
+	}
+
 	if err = appCtx.DB.InitDB(); err != nil {
 		log.Fatalf("FATAL: failed to init db - %v", err)
 	}
@@ -61,11 +84,43 @@ func getLouisAppContext() *louis.AppContext {
 		log.Fatalf("FATAL: failed to parse json from ensure-transforms.json - %v", err)
 	}
 
-	err = appCtx.DB.EnsureTransformations(tlist.Transformations)
+	allTransformations, err := tlist.All()
+	if err != nil {
+		log.Fatalf("FATAL: invalid transformation set in ensure-transforms.json - %v", err)
+	}
+
+	if appCtx.Config.SRGBProfile != "" && !transformations.HasOperation("icc_transform") {
+		log.Printf("WARN: libvips is built without lcms2, images are not converted to sRGB")
+	}
+	// formats are checked once, avif sample is decoded here rather than on first upload
+	log.Printf("INFO: accepted input formats %v", transformations.InputFormats())
+
+	if err = transformations.Validate(allTransformations); err != nil {
+		log.Fatalf("FATAL: invalid transformation in ensure-transforms.json - %v", err)
+	}
+
+	err = appCtx.DB.EnsureTransformations(allTransformations)
 	if err != nil {
 		log.Printf("ERROR: failed to ensure transformations: %v", err)
 	}
 
+	for _, ts := range tlist.Tags {
+		store, err := appCtx.Storage.Profile(ts.Storage)
+		if err != nil {
+			log.Fatalf("FATAL: invalid storage of tag %q - %v", ts.Tag, err)
+		}
+		if ts.Private && !store.SupportsACL() {
+			log.Fatalf("FATAL: tag %q is private, but its storage does not support ACLs", ts.Tag)
+		}
+	}
+	if !storage.ValidStorageClass(appCtx.Config.RealStorageClass) {
+		log.Fatalf("FATAL: unsupported REAL_STORAGE_CLASS %q", appCtx.Config.RealStorageClass)
+	}
+
+	if err = appCtx.DB.EnsureTagSettings(tlist.Tags); err != nil {
+		log.Fatalf("FATAL: failed to ensure tag settings - %v", err)
+	}
+
 	// TODO: move cleanup to separate job (think about it)
 	appCtx.WithWork()
 	return appCtx
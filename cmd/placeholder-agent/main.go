@@ -0,0 +1,86 @@
+package main
+
+// placeholder-agent calculates blurhash, dominant color and preview
+// for images uploaded before placeholders were introduced
+
+import (
+	"github.com/KazanExpress/louis/internal/app/louis"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+func main() {
+
+	var err error
+	var appCtx = new(louis.AppContext)
+	appCtx.Config = utils.InitConfig()
+	appCtx.DB, err = storage.Open(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Storage, err = storage.InitS3Context(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var service = louis.NewLouisService(appCtx)
+	appCtx.ImageService = service
+
+	var batchSize = 10
+
+	if len(os.Args) > 1 {
+		var batch, err = strconv.Atoi(os.Args[1])
+		if err != nil {
+			log.Printf("failed to parse batch size argument. ignoring it")
+		} else {
+			batchSize = batch
+		}
+	}
+
+	var lastID int64
+	var processed, failed int
+	for {
+		var res = new([]storage.Image)
+		// images are iterated by id, because processed ones leave the selection
+		var err = appCtx.DB.
+			Where("id > ? and blur_hash = '' and deleted = false and transforms_uploaded = true", lastID).
+			Order("id").
+			Limit(batchSize).
+			Find(res).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var mx sync.Mutex
+		wg.Add(len(*res))
+		for i := range *res {
+			var img = (*res)[i]
+			log.Printf("proccesing %v", img.Key)
+			go func(img storage.Image) {
+				defer wg.Done()
+				var err = service.UpdatePlaceholder(&img)
+				mx.Lock()
+				defer mx.Unlock()
+				processed++
+				if err != nil {
+					failed++
+					log.Printf("failed to update placeholder of %v - %s", img.Key, err)
+				}
+			}(img)
+			lastID = img.ID
+		}
+		wg.Wait()
+
+		if len(*res) < batchSize {
+			break
+		}
+	}
+
+	log.Printf("from %v images %v failed", processed, failed)
+}
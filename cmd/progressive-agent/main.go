@@ -8,6 +8,7 @@ import (
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"log"
 	"os"
+	"path"
 	"strconv"
 	"sync"
 )
@@ -57,13 +58,22 @@ func main() {
 			go func(img storage.Image) {
 				defer wg.Done()
 				if !img.Progressive {
-					var images, err = appCtx.Storage.ListFiles(img.Key)
+					var store, err = appCtx.Storage.Profile(img.Storage)
+					if err != nil {
+						log.Printf("failed to get storage of image - %s", err)
+						return
+					}
+					images, err := store.ListFiles(louis.ObjectFolder(&img) + "/")
 					if err != nil {
 						log.Printf("failed to get list of transformations - %s", err)
 						return
 					}
 					for _, id := range images {
-						var im, err = appCtx.Storage.GetObject(*id.Key)
+						// encrypted real copy would be uploaded back without encryption
+						if img.RealEncryptionKey != "" && path.Base(*id.Key) == louis.RealTransformName+"."+louis.ImageExtension {
+							continue
+						}
+						var im, err = store.GetObject(*id.Key)
 						if err != nil {
 							log.Printf("failed to get objeet - %s", err)
 							return
@@ -75,7 +85,7 @@ func main() {
 							return
 						}
 
-						_, err = appCtx.Storage.UploadFile(bytes.NewReader(transformed), *id.Key)
+						_, err = store.UploadFile(bytes.NewReader(transformed), *id.Key)
 						if err != nil {
 							log.Printf(" failed to upload transformed image - %s", err)
 							return
@@ -0,0 +1,86 @@
+package main
+
+// replica-sync copies "real" objects of images which are not replicated yet to REPLICA_PROFILE or REPLICA_DIR,
+// it should be run after replica is configured and to retry failed replications
+
+import (
+	"github.com/KazanExpress/louis/internal/app/louis"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+
+	var err error
+	var appCtx = new(louis.AppContext)
+	appCtx.Config = utils.InitConfig()
+	appCtx.DB, err = storage.Open(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Storage, err = storage.InitS3Context(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Encryption, err = storage.NewKeyring(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.Replica, err = storage.InitReplica(appCtx.Config, appCtx.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if appCtx.Replica == nil {
+		log.Fatal("REPLICA_PROFILE and REPLICA_DIR are not set, nothing to sync")
+	}
+
+	var service = louis.NewLouisService(appCtx)
+	appCtx.ImageService = service
+
+	var batchSize = 100
+
+	if len(os.Args) > 1 {
+		var batch, err = strconv.Atoi(os.Args[1])
+		if err != nil {
+			log.Printf("failed to parse batch size argument. ignoring it")
+		} else {
+			batchSize = batch
+		}
+	}
+
+	var lastID int64
+	var processed, failed int
+	for {
+		var res = new([]storage.Image)
+		var err = appCtx.DB.
+			Where("id > ? and with_real_copy = true and transforms_uploaded = true and replica_status <> ?", lastID, storage.ReplicaDone).
+			Order("id").
+			Limit(batchSize).
+			Find(res).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for i := range *res {
+			var img = &(*res)[i]
+			processed++
+			if err := service.Replicate(img.Key); err != nil {
+				failed++
+				log.Printf("failed to replicate %v - %s", img.Key, err)
+			}
+			lastID = img.ID
+		}
+
+		if len(*res) < batchSize {
+			break
+		}
+	}
+
+	log.Printf("from %v images %v replicated, %v failed", processed, processed-failed, failed)
+}
@@ -0,0 +1,80 @@
+package main
+
+// url-migrator rewrites stored URLs of images by PUBLIC_URL_TEMPLATE,
+// it should be run after template or CDN hosts are changed
+
+import (
+	"github.com/KazanExpress/louis/internal/app/louis"
+	"github.com/KazanExpress/louis/internal/pkg/publicurl"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+
+	var err error
+	var appCtx = new(louis.AppContext)
+	appCtx.Config = utils.InitConfig()
+	appCtx.DB, err = storage.Open(appCtx.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appCtx.PublicURL, err = publicurl.New(appCtx.Config.PublicURLTemplate, appCtx.Config.CDNBaseURL, appCtx.Config.CDNTagHosts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !appCtx.PublicURL.Enabled() {
+		log.Fatal("PUBLIC_URL_TEMPLATE is not set, nothing to rewrite")
+	}
+
+	var service = louis.NewLouisService(appCtx)
+	appCtx.ImageService = service
+
+	var batchSize = 100
+
+	if len(os.Args) > 1 {
+		var batch, err = strconv.Atoi(os.Args[1])
+		if err != nil {
+			log.Printf("failed to parse batch size argument. ignoring it")
+		} else {
+			batchSize = batch
+		}
+	}
+
+	var lastID int64
+	var processed, rewritten, failed int
+	for {
+		var res = new([]storage.Image)
+		var err = appCtx.DB.
+			Where("id > ? and url <> ''", lastID).
+			Order("id").
+			Limit(batchSize).
+			Find(res).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for i := range *res {
+			var img = &(*res)[i]
+			changed, err := service.RewriteURL(img)
+			processed++
+			if err != nil {
+				failed++
+				log.Printf("failed to rewrite url of %v - %s", img.Key, err)
+			} else if changed {
+				rewritten++
+			}
+			lastID = img.ID
+		}
+
+		if len(*res) < batchSize {
+			break
+		}
+	}
+
+	log.Printf("from %v images %v rewritten, %v failed", processed, rewritten, failed)
+}
@@ -0,0 +1,106 @@
+package louis
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// batchItem - arguments of one file of upload request or error of their validation
+type batchItem struct {
+	args *requestArgs
+	err  error
+}
+
+// batchItemResult - response of upload of one file in batch
+type batchItemResult struct {
+	Status  int             `json:"status"`
+	Error   string          `json:"error"`
+	Code    string          `json:"code,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// bufferedResponse - http.ResponseWriter which keeps response of handler in memory
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (br *bufferedResponse) Header() http.Header {
+	return br.header
+}
+
+func (br *bufferedResponse) Write(data []byte) (int, error) {
+	return br.body.Write(data)
+}
+
+func (br *bufferedResponse) WriteHeader(status int) {
+	br.status = status
+}
+
+// result - converts response written by handler to batch item result
+func (br *bufferedResponse) result() batchItemResult {
+	var resp struct {
+		Error   string          `json:"error"`
+		Code    string          `json:"code"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(br.body.Bytes(), &resp); err != nil {
+		return batchItemResult{Status: br.status, Error: br.body.String()}
+	}
+	return batchItemResult{Status: br.status, Error: resp.Error, Code: resp.Code, Payload: resp.Payload}
+}
+
+// inBatch - applies handler to every file of request validated by validateBatch,
+// files are handled in parallel by at most BATCH_CONCURRENCY goroutines and failure of one file
+// does not affect others, request with one file is handled as usual
+func inBatch(handler sessionHandler) sessionHandler {
+	return sessionHandler(func(s *session, w http.ResponseWriter, r *http.Request) {
+		if len(s.batch) <= 1 {
+			handler(s, w, r)
+			return
+		}
+
+		var concurrency = s.ctx.Config.BatchConcurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		var results = make([]batchItemResult, len(s.batch))
+		var slots = make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		wg.Add(len(s.batch))
+		for i := range s.batch {
+			slots <- struct{}{}
+			go func(i int) {
+				defer func() {
+					if err := recover(); err != nil {
+						log.Printf("ERROR: catch panic in batch upload %v", err)
+						results[i] = batchItemResult{Status: http.StatusInternalServerError, Error: "internal server error"}
+					}
+					<-slots
+					wg.Done()
+				}()
+
+				var response = newBufferedResponse()
+				if failOnArgsError(response, s.batch[i].err) {
+					results[i] = response.result()
+					return
+				}
+				var itemSession = &session{ctx: s.ctx, userID: s.userID, args: s.batch[i].args}
+				handler(itemSession, response, r)
+				results[i] = response.result()
+			}(i)
+		}
+		wg.Wait()
+
+		log.Printf("INFO: batch of %v files handled", len(s.batch))
+		respondWithJSON(w, "", results, http.StatusOK)
+	})
+}
@@ -1,6 +1,8 @@
 package louis
 
 import (
+	"github.com/KazanExpress/louis/internal/pkg/keylayout"
+	"github.com/KazanExpress/louis/internal/pkg/publicurl"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"github.com/gocraft/work"
 	"github.com/gomodule/redigo/redis"
@@ -22,7 +24,15 @@ type AppContext struct {
 	Enqueuer     *work.Enqueuer
 	Storage      *storage.S3Context
 	ImageService ImageService
-	Dropped      bool
+	// PublicURL - template of image URLs, S3 locations are used if it is nil or empty
+	PublicURL *publicurl.Template
+	// KeyLayout - folder layout of new images, legacy layout is used if it is nil
+	KeyLayout *keylayout.Layout
+	// Replica - store "real" copies are replicated to, replication is disabled if it is nil
+	Replica storage.ObjectStore
+	// Encryption - server side encryption of stored objects, objects are not encrypted if it is nil
+	Encryption *storage.Keyring
+	Dropped    bool
 }
 
 func (appCtx *AppContext) DropAll() error {
@@ -0,0 +1,81 @@
+package louis
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/jinzhu/gorm"
+	"log"
+)
+
+// dedupMode - returns deduplication mode of image tags,
+// "copy" wins if tags have different modes, because it keeps images independent
+func (appCtx *AppContext) dedupMode(tags []string) (string, error) {
+	var settings, err = appCtx.DB.GetTagSettings(tags)
+	if err != nil {
+		return "", err
+	}
+	var mode string
+	for _, ts := range settings {
+		if ts.Dedup == storage.DedupCopy || mode == "" {
+			mode = ts.Dedup
+		}
+	}
+	return mode, nil
+}
+
+// deduplicate - returns payload of existing image if the same file was uploaded with the same tags before
+// and deduplication is enabled for them, nil payload means upload is not a duplicate
+func (appCtx *AppContext) deduplicate(args *requestArgs, userID int32, claim bool) (*uploadResponsePayload, error) {
+	var mode, err = appCtx.dedupMode(args.tags)
+	if err != nil {
+		log.Printf("WARN: failed to get dedup mode of tags %v - %v", args.tags, err)
+		return nil, nil
+	}
+	if mode == "" {
+		return nil, nil
+	}
+
+	source, err := appCtx.DB.FindImageByContentHash(args.contentHash, userID, args.tags)
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Printf("WARN: failed to find duplicate of image - %v", err)
+		}
+		return nil, nil
+	}
+
+	var image = source
+	if mode == storage.DedupCopy {
+		if _, err = appCtx.createImageRecord(args, userID); err != nil {
+			return nil, err
+		}
+		if err = appCtx.ImageService.Copy(source, args.imageKey); err != nil {
+			return nil, err
+		}
+		if image, err = appCtx.DB.QueryImageByKey(args.imageKey); err != nil {
+			return nil, err
+		}
+		if !claim {
+			if err = appCtx.claimOrCleanup(image.Key, userID, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if claim {
+		if err = appCtx.DB.SetClaimImage(image.Key, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	trans, err := appCtx.DB.GetTransformations(image.ID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := appCtx.imageMetadataPayload(image, trans)
+	if err != nil {
+		return nil, err
+	}
+	var payload = metadata.uploadResponsePayload
+	payload.DuplicateOf = source.Key
+	log.Printf("INFO: upload is duplicate of image %v, responded with image %v", source.Key, image.Key)
+	return &payload, nil
+}
@@ -0,0 +1,183 @@
+package louis
+
+import (
+	"encoding/json"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/gocraft/work"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExpireDirectUploadTask - deletes staged object and state of direct upload
+const ExpireDirectUploadTask = "expire_direct_upload"
+
+type directUploadRequest struct {
+	Key        string   `json:"key"`
+	Tags       []string `json:"tags"`
+	CropPoints string   `json:"cropPoints"`
+}
+
+type directUploadPayload struct {
+	ID        string    `json:"id"`
+	ImageKey  string    `json:"key"`
+	UploadURL string    `json:"uploadUrl"`
+	Expires   time.Time `json:"expires"`
+}
+
+func (appCtx *AppContext) stagingKey(uploadID string) string {
+	return appCtx.Config.StagingPrefix + uploadID
+}
+
+// handleCreateDirectUpload - returns presigned URL which client uploads image to bucket with,
+// image is processed when client completes upload
+func handleCreateDirectUpload(s *session, w http.ResponseWriter, r *http.Request) {
+	var req directUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithJSON(w, "invalid request body: "+err.Error(), nil, http.StatusBadRequest)
+		return
+	}
+
+	var tags, err = parseTags(strings.Join(req.Tags, ","))
+	if failOnArgsError(w, err) {
+		return
+	}
+	if _, err = parseCropPoints(req.CropPoints); failOnArgsError(w, err) {
+		return
+	}
+	if req.Key == "" {
+		req.Key = xid.New().String()
+	} else if _, err = s.ctx.DB.QueryImageByKey(req.Key); err == nil {
+		respondWithJSON(w, "image with such key is already exists", nil, http.StatusBadRequest)
+		return
+	} else if err != gorm.ErrRecordNotFound {
+		failOnError(w, err, "failed to get image", http.StatusInternalServerError)
+		return
+	}
+
+	var upload = &storage.DirectUpload{
+		ID:         xid.New().String(),
+		UserID:     s.userID,
+		ImageKey:   req.Key,
+		Tags:       tags,
+		CropPoints: req.CropPoints,
+		Claim:      s.claim,
+		Status:     storage.UploadPending,
+		ExpireDate: time.Now().Add(s.ctx.Config.DirectUploadExpiration),
+	}
+
+	uploadURL, err := s.ctx.ImageService.PresignStaged(s.ctx.stagingKey(upload.ID))
+	if failOnError(w, err, "failed to presign upload url", http.StatusInternalServerError) {
+		return
+	}
+	if failOnError(w, s.ctx.DB.CreateDirectUpload(upload), "failed to create upload", http.StatusInternalServerError) {
+		return
+	}
+
+	_, err = s.ctx.Enqueuer.EnqueueUniqueIn(ExpireDirectUploadTask, int64(s.ctx.Config.DirectUploadExpiration.Seconds()), map[string]interface{}{"id": upload.ID})
+	if err != nil {
+		log.Printf("ERROR: failed to enqueue expiration of direct upload %v: %v", upload.ID, err)
+	}
+
+	respondWithJSON(w, "", directUploadPayload{
+		ID:        upload.ID,
+		ImageKey:  upload.ImageKey,
+		UploadURL: uploadURL,
+		Expires:   upload.ExpireDate,
+	}, http.StatusOK)
+}
+
+// handleCompleteDirectUpload - validates and transforms staged image like uploaded one, staged object is deleted
+func handleCompleteDirectUpload(s *session, w http.ResponseWriter, r *http.Request) {
+	var upload, err = s.ctx.DB.GetDirectUpload(mux.Vars(r)["uploadId"])
+	if err == gorm.ErrRecordNotFound || err == nil && upload.UserID != s.userID {
+		respondWithJSON(w, "upload not found", nil, http.StatusNotFound)
+		return
+	}
+	if failOnError(w, err, "failed to get upload", http.StatusInternalServerError) {
+		return
+	}
+	if upload.Status == storage.UploadDone || upload.Status == storage.UploadFailed {
+		respondWithJSON(w, "upload is already completed", nil, http.StatusConflict)
+		return
+	}
+	if time.Now().After(upload.ExpireDate) {
+		respondWithJSON(w, "upload is expired", nil, http.StatusGone)
+		return
+	}
+
+	// status is checked again by update, so concurrent requests do not process the same staged object
+	err = s.ctx.DB.StartDirectUpload(upload.ID)
+	if err == storage.ErrUploadLocked {
+		respondWithJSON(w, "upload is completed by another request", nil, http.StatusLocked)
+		return
+	}
+	if failOnError(w, err, "failed to start upload", http.StatusInternalServerError) {
+		return
+	}
+
+	var stagingKey = s.ctx.stagingKey(upload.ID)
+	image, err := s.ctx.ImageService.FetchStaged(stagingKey)
+	if err == storage.NoSuchKeyError {
+		// upload can be completed again once client puts image
+		if serr := s.ctx.DB.SetDirectUploadStatus(upload.ID, storage.UploadPending, "", ""); serr != nil {
+			log.Printf("ERROR: failed to save status of direct upload %v: %v", upload.ID, serr)
+		}
+		respondWithJSON(w, "image is not uploaded to upload url yet", nil, http.StatusConflict)
+		return
+	}
+
+	var payload *uploadResponsePayload
+	if err == nil {
+		var args = &requestArgs{image: image, tags: upload.Tags, imageKey: upload.ImageKey}
+		if args.cropSquare, err = parseCropPoints(upload.CropPoints); err == nil {
+			payload, err = s.ctx.processUpload(args, upload.UserID, upload.Claim)
+		}
+	}
+
+	if derr := s.ctx.ImageService.DeleteStaged(stagingKey); derr != nil {
+		log.Printf("WARN: failed to delete staged object %v: %v", stagingKey, derr)
+	}
+
+	var status, code, message = storage.UploadDone, "", ""
+	if err != nil {
+		status, message = storage.UploadFailed, err.Error()
+		if uerr, ok := err.(*uploadError); ok {
+			code = uerr.Code
+		}
+		log.Printf("ERROR: failed to process direct upload %v: %v", upload.ID, err)
+	}
+	if serr := s.ctx.DB.SetDirectUploadStatus(upload.ID, status, code, message); serr != nil {
+		log.Printf("ERROR: failed to save status of direct upload %v: %v", upload.ID, serr)
+	}
+	if failOnArgsError(w, err) {
+		return
+	}
+
+	log.Printf("INFO: direct upload %v completed as image with key=%v", upload.ID, payload.ImageKey)
+	respondWithJSON(w, "", payload, http.StatusOK)
+}
+
+// ExpireDirectUpload - deletes staged object and state of direct upload after DIRECT_UPLOAD_EXPIRATION
+func (appCtx *CleanupTaskCtx) ExpireDirectUpload(job *work.Job) error {
+	var id = job.ArgString("id")
+	if err := job.ArgError(); err != nil {
+		return err
+	}
+
+	if err := appCtx.ImageService.DeleteStaged(appCtx.stagingKey(id)); err != nil {
+		log.Printf("ERROR: failed to delete staged object of direct upload %v: %v", id, err)
+		return err
+	}
+	if err := appCtx.DB.DeleteDirectUpload(id); err != nil {
+		return err
+	}
+
+	log.Printf("CLEANUP_POOL: direct upload %v expired", id)
+	return nil
+}
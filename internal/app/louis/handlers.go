@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
 	"github.com/KazanExpress/louis/internal/pkg/transformations"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"github.com/gorilla/mux"
-	"github.com/lib/pq"
-	"github.com/rs/xid"
+	"github.com/jinzhu/gorm"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -24,12 +24,22 @@ type requestArgs struct {
 	tags       []string
 	imageKey   string
 	cropSquare *utils.Square
+	// contentHash - hash of image used for deduplication
+	contentHash string
+	// checked - image is already checked by checkUpload
+	checked bool
+	// parentKey - key of image which derived image is made from, derived images are not deduplicated
+	parentKey string
 }
 
 type session struct {
 	ctx    *AppContext
 	userID int32
 	args   *requestArgs
+	// batch - arguments of every file of upload request
+	batch []batchItem
+	// claim - if uploaded image should be claimed, it is set by authorizeUpload
+	claim bool
 }
 
 type sessionHandler = func(*session, http.ResponseWriter, *http.Request)
@@ -49,26 +59,6 @@ func withSession(ctx *AppContext) func(sessionHandler) http.HandlerFunc {
 
 }
 
-func (s *session) tryCreateImageRecord(w http.ResponseWriter, r *http.Request) (int64, bool) {
-
-	if s.args.imageKey == "" {
-		s.args.imageKey = xid.New().String()
-	}
-
-	imgID, err := s.ctx.DB.AddImage(s.args.imageKey, s.userID, s.args.tags...)
-
-	if err != nil {
-
-		if pger, ok := err.(*pq.Error); ok && pger.Constraint == "images_key_key" {
-			failOnError(w, err, "image with such key is already exists", http.StatusBadRequest)
-		} else {
-			failOnError(w, err, "error on creating db record", http.StatusInternalServerError)
-		}
-		return imgID, false
-	}
-	return imgID, true
-}
-
 func parseClaimRequestBody(r *http.Request) (*imageData, error) {
 	var body, err = ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -127,91 +117,185 @@ func handleClaim(s *session, w http.ResponseWriter, r *http.Request) {
 }
 
 func handleUploadWithClaim(s *session, w http.ResponseWriter, r *http.Request) {
-	imgID, created := s.tryCreateImageRecord(w, r)
+	s.upload(w, true)
+}
 
-	if !created {
-		// response in prev method
+func handleUpload(s *session, w http.ResponseWriter, r *http.Request) {
+	s.upload(w, false)
+}
+
+// upload - passes validated file of request to upload pipeline
+func (s *session) upload(w http.ResponseWriter, claim bool) {
+	var payload, err = s.ctx.processUpload(s.args, s.userID, claim)
+	if failOnArgsError(w, err) {
 		return
 	}
+	respondWithJSON(w, "", payload, http.StatusOK)
+}
 
-	transformsURLs, err := s.ctx.ImageService.Upload(&UploadArgs{
-		ImageID:  imgID,
-		ImageKey: s.args.imageKey,
-		Params: transformations.TransformParams{
-			Image:      s.args.image,
-			CropSquare: s.args.cropSquare,
-		},
-	})
+func handleRestore(s *session, w http.ResponseWriter, r *http.Request) {
+	var vars = mux.Vars(r)
+	var imageKey, passed = vars["imageKey"]
 
-	if failOnError(w, err, "failed to upload transforms", http.StatusInternalServerError) {
+	if !passed {
+		respondWithJSON(w, "imageKey is not passed", nil, http.StatusBadRequest)
 		return
 	}
 
-	if failOnError(w, s.ctx.DB.SetImageURL(s.args.imageKey, s.userID, transformsURLs[OriginalTransformName]), "failed to set image url", http.StatusInternalServerError) {
+	var err = s.ctx.ImageService.Restore(imageKey)
+	if err != nil {
+		if err == ImageCanNotBeRestoredError {
+			respondWithJSON(w, err.Error(), nil, http.StatusPreconditionFailed)
+		} else {
+			respondWithJSON(w, "failed to restore image: "+err.Error(), nil, http.StatusInternalServerError)
+		}
 		return
 	}
 
-	if failOnError(w, s.ctx.DB.SetClaimImage(s.args.imageKey, s.userID), "failed to claim image", http.StatusInternalServerError) {
+	respondWithJSON(w, "", "ok", http.StatusAccepted)
+}
+
+func handleGetImage(s *session, w http.ResponseWriter, r *http.Request) {
+	var imageKey = mux.Vars(r)["imageKey"]
+
+	var image, err = s.ctx.DB.QueryImageByKey(imageKey)
+	if err == gorm.ErrRecordNotFound {
+		respondWithJSON(w, "image not found", nil, http.StatusNotFound)
+		return
+	}
+	if failOnError(w, err, "failed to get image", http.StatusInternalServerError) {
 		return
 	}
 
-	log.Printf("INFO: image with key %v and %v transforms uploaded and claimed", s.args.imageKey, len(transformsURLs))
-	respondWithJSON(w, "", makeTransformsPayload(s.args.imageKey, transformsURLs), 200)
-}
+	var trans []storage.Transformation
+	if image.TransformsUploaded && !image.Deleted {
+		trans, err = s.ctx.DB.GetTransformations(image.ID)
+		if failOnError(w, err, "failed to get transformations", http.StatusInternalServerError) {
+			return
+		}
+	}
 
-func handleUpload(s *session, w http.ResponseWriter, r *http.Request) {
-	imgID, created := s.tryCreateImageRecord(w, r)
+	payload, err := s.ctx.imageMetadataPayload(image, trans)
+	if failOnError(w, err, "failed to sign urls", http.StatusInternalServerError) {
+		return
+	}
+	respondWithJSON(w, "", payload, http.StatusOK)
+}
 
-	if !created {
-		// response in prev method
+// handleReplace - uploads new version of image keeping its key and tags
+func handleReplace(s *session, w http.ResponseWriter, r *http.Request) {
+	var image, err = s.ctx.DB.QueryImageByKey(mux.Vars(r)["imageKey"])
+	if err == gorm.ErrRecordNotFound {
+		respondWithJSON(w, "image not found", nil, http.StatusNotFound)
+		return
+	}
+	if failOnError(w, err, "failed to get image", http.StatusInternalServerError) {
 		return
 	}
-	transformsURLs, err := s.ctx.ImageService.Upload(&UploadArgs{
-		ImageID:  imgID,
-		ImageKey: s.args.imageKey,
-		Params: transformations.TransformParams{
-			Image:      s.args.image,
-			CropSquare: s.args.cropSquare,
-		},
-	})
 
-	if failOnError(w, err, "failed to upload transforms", http.StatusInternalServerError) {
+	// file is validated by tags of stored image, not by tags of request
+	if failOnUploadError(w, s.ctx.checkUpload(s.args.image, image.Tags)) {
 		return
 	}
 
-	_, err = s.ctx.Enqueuer.EnqueueUniqueIn(CleanupTask, int64(s.ctx.Config.CleanUpDelay*60), map[string]interface{}{"key": s.args.imageKey})
-	if err != nil {
-		log.Printf("ERROR: failed to enqueue clean up task: %v", err)
+	results, err := s.ctx.ImageService.Replace(image, transformations.TransformParams{
+		Image:      s.args.image,
+		CropSquare: s.args.cropSquare,
+	})
+	if err == ImageCanNotBeReplacedError {
+		respondWithJSON(w, err.Error(), nil, http.StatusPreconditionFailed)
+		return
+	}
+	if err == storage.ErrVersionConflict {
+		respondWithJSON(w, err.Error(), nil, http.StatusConflict)
+		return
+	}
+	if failOnError(w, err, "failed to upload transforms", http.StatusInternalServerError) {
+		return
 	}
 
-	if failOnError(w, s.ctx.DB.SetImageURL(s.args.imageKey, s.userID, transformsURLs[OriginalTransformName]), "failed to set image url", http.StatusInternalServerError) {
+	log.Printf("INFO: image with key %v replaced with version %v", image.Key, results.Version)
+	payload, err := s.ctx.transformsPayload(image.Key, results)
+	if failOnError(w, err, "failed to sign urls", http.StatusInternalServerError) {
 		return
 	}
+	respondWithJSON(w, "", payload, http.StatusOK)
+}
 
-	log.Printf("INFO: image with key %v and %v transforms uploaded", s.args.imageKey, len(transformsURLs))
-	respondWithJSON(w, "", makeTransformsPayload(s.args.imageKey, transformsURLs), 200)
+// deriveRequest - edits applied to stored image, crop rectangle is given in coordinates
+// of stored image and is applied before rotation and flip
+type deriveRequest struct {
+	Key  string `json:"key"`
+	Crop *struct {
+		X      int `json:"x"`
+		Y      int `json:"y"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"crop"`
+	Rotate int    `json:"rotate"`
+	Flip   string `json:"flip"`
 }
 
-func handleRestore(s *session, w http.ResponseWriter, r *http.Request) {
-	var vars = mux.Vars(r)
-	var imageKey, passed = vars["imageKey"]
+// operations - converts request to chain operations
+func (req *deriveRequest) operations() ([]storage.Operation, error) {
+	var ops []storage.Operation
+	if req.Crop != nil {
+		ops = append(ops, storage.Operation{Op: storage.OpCrop, X: req.Crop.X, Y: req.Crop.Y, Width: req.Crop.Width, Height: req.Crop.Height})
+	}
+	if req.Rotate != 0 {
+		ops = append(ops, storage.Operation{Op: storage.OpRotate, Angle: req.Rotate})
+	}
+	if req.Flip != "" {
+		ops = append(ops, storage.Operation{Op: storage.OpFlip, Direction: req.Flip})
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("crop, rotate or flip is required")
+	}
+	for i := range ops {
+		if err := ops[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
 
-	if !passed {
-		respondWithJSON(w, "imageKey is not passed", nil, http.StatusBadRequest)
+func handleDerive(s *session, w http.ResponseWriter, r *http.Request) {
+	var req deriveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithJSON(w, "invalid request body: "+err.Error(), nil, http.StatusBadRequest)
 		return
 	}
-
-	var err = s.ctx.ImageService.Restore(imageKey)
+	var ops, err = req.operations()
 	if err != nil {
-		if err == ImageCanNotBeRestoredError {
-			respondWithJSON(w, err.Error(), nil, http.StatusPreconditionFailed)
-		} else {
-			respondWithJSON(w, "failed to restore image: "+err.Error(), nil, http.StatusInternalServerError)
-		}
+		respondWithJSON(w, err.Error(), nil, http.StatusBadRequest)
 		return
 	}
 
-	respondWithJSON(w, "", "ok", http.StatusAccepted)
+	parent, err := s.ctx.DB.QueryImageByKey(mux.Vars(r)["imageKey"])
+	if err == gorm.ErrRecordNotFound {
+		respondWithJSON(w, "image not found", nil, http.StatusNotFound)
+		return
+	}
+	if failOnError(w, err, "failed to get image", http.StatusInternalServerError) {
+		return
+	}
+
+	image, err := s.ctx.ImageService.Derive(parent, ops)
+	if err == ImageCanNotBeDerivedError || err == storage.NoSuchKeyError {
+		respondWithJSON(w, err.Error(), nil, http.StatusPreconditionFailed)
+		return
+	}
+	if failOnError(w, err, "failed to derive image", http.StatusBadRequest) {
+		return
+	}
+
+	// derived image is checked by pipeline against the same rules as uploaded one
+	payload, err := s.ctx.processUpload(&requestArgs{image: image, tags: parent.Tags, imageKey: req.Key, parentKey: parent.Key}, s.userID, true)
+	if failOnArgsError(w, err) {
+		return
+	}
+	log.Printf("INFO: image with key %v derived from %v", payload.ImageKey, parent.Key)
+	respondWithJSON(w, "", payload, http.StatusOK)
 }
 
 // simple handlers without need of session
@@ -225,6 +309,18 @@ func handleFree(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(200)
 }
 
+type capabilitiesPayload struct {
+	InputFormats  []string `json:"inputFormats"`
+	OutputFormats []string `json:"outputFormats"`
+}
+
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, "", capabilitiesPayload{
+		InputFormats:  transformations.InputFormats(),
+		OutputFormats: transformations.OutputFormats(),
+	}, http.StatusOK)
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	health := utils.GetHealthStats()
 	body, _ := json.Marshal(health)
@@ -2,17 +2,26 @@ package louis
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
 	"regexp"
 	// "github.com/KazanExpress/louis/internal/pkg/queue"
+	"github.com/KazanExpress/louis/internal/pkg/probe"
 	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/transformations"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/gocraft/work"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/onsi/gomega"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"io"
+	"io/ioutil"
 	"log"
 	"mime/multipart"
 	"strings"
@@ -22,6 +31,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 
@@ -110,6 +120,24 @@ func (s *Suite) TestClaimAuthorization() {
 	s.Equal(http.StatusUnauthorized, response.Code, "should respond with 401")
 
 }
+func (s *Suite) TestCapabilities() {
+
+	request, err := http.NewRequest("GET", "http://localhost:8000/capabilities", nil)
+	s.NoError(err)
+
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+
+	s.Equal(http.StatusOK, response.Code, "should respond with 200")
+
+	var resp struct {
+		Payload capabilitiesPayload `json:"payload"`
+	}
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	s.Contains(resp.Payload.InputFormats, "jpeg")
+	s.Contains(resp.Payload.OutputFormats, "jpeg")
+}
+
 func (s *Suite) TestUploadAuthorization() {
 
 	path, _ := os.Getwd()
@@ -218,6 +246,11 @@ func (s *Suite) TestUploadWithClaim() {
 	assert.True(img.Approved)
 	assert.Equal(img.URL, url, "url from response and in database should be the same")
 
+	var placeholder = payload["placeholder"].(map[string]interface{})
+	assert.Equal(img.BlurHash, placeholder["blurhash"])
+	assert.Regexp("^#[0-9a-f]{6}$", placeholder["dominantColor"])
+	assert.Regexp("^data:image/jpeg;base64,", placeholder["preview"])
+
 }
 
 func (s *Suite) TestUploadWithName() {
@@ -291,6 +324,193 @@ func (s *Suite) TestUploadWithTags() {
 
 }
 
+func (s *Suite) TestUploadWithTagLimits() {
+
+	assert := assert.New(s.T())
+
+	failIfError(s.T(), s.appCtx.DB.EnsureTagSettings([]storage.TagSettings{{Tag: "icon", MaxWidth: 64}}), "failed to ensure tag settings")
+
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	request, err := newFileUploadRequest("http://localhost:8000/upload", map[string]string{"tags": "icon"}, "file", path)
+	failIfError(s.T(), err, "failed to create file upload request")
+
+	request.Header.Add("Authorization", s.appCtx.Config.PublicKey)
+
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+
+	assert.Equal(http.StatusBadRequest, response.Code, "should respond with 400")
+
+	var resp responseTemplate
+	failIfError(s.T(), json.Unmarshal(response.Body.Bytes(), &resp), "failed to unmarshall response body")
+
+	assert.Equal(probe.CodeTooWide, resp.Code)
+	assert.NotEmpty(resp.Error)
+}
+
+func (s *Suite) TestUploadDeduplicated() {
+	failIfError(s.T(), s.appCtx.DB.EnsureTagSettings([]storage.TagSettings{
+		{Tag: "reused", Dedup: storage.DedupReuse},
+		{Tag: "copied", Dedup: storage.DedupCopy},
+	}), "failed to ensure tag settings")
+
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	var upload = func(tags string) map[string]interface{} {
+		request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"tags": tags}, "file", path)
+		s.NoError(err)
+		request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+		response := httptest.NewRecorder()
+		s.server.appRouter.ServeHTTP(response, request)
+		s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+		var resp responseTemplate
+		s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+		return resp.Payload.(map[string]interface{})
+	}
+
+	var first = upload("reused")
+	s.Nil(first["duplicateOf"])
+	var second = upload("reused")
+	s.Equal(first["key"], second["key"])
+	s.Equal(first["key"], second["duplicateOf"])
+	s.Equal(first["originalUrl"], second["originalUrl"])
+
+	first = upload("copied")
+	second = upload("copied")
+	s.NotEqual(first["key"], second["key"])
+	s.Equal(first["key"], second["duplicateOf"])
+
+	img, err := s.appCtx.DB.QueryImageByKey(second["key"].(string))
+	s.NoError(err)
+	s.True(img.TransformsUploaded)
+	s.True(img.Approved)
+	s.Equal(second["originalUrl"], img.URL)
+	s.Contains(img.URL, img.Key+"/original.jpg")
+}
+
+func (s *Suite) TestUploadWithRequiredTag() {
+
+	assert := assert.New(s.T())
+
+	failIfError(s.T(), s.appCtx.DB.EnsureTagSettings([]storage.TagSettings{{Tag: "cover", RequiredTags: []string{"shop"}, Formats: []string{"jpeg"}}}), "failed to ensure tag settings")
+
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+
+	for tags, code := range map[string]string{"cover": ErrCodeMissingTag, "cover,shop": ""} {
+		request, err := newFileUploadRequest("http://localhost:8000/upload", map[string]string{"tags": tags}, "file", path)
+		failIfError(s.T(), err, "failed to create file upload request")
+
+		request.Header.Add("Authorization", s.appCtx.Config.PublicKey)
+
+		response := httptest.NewRecorder()
+		s.server.appRouter.ServeHTTP(response, request)
+
+		var resp responseTemplate
+		failIfError(s.T(), json.Unmarshal(response.Body.Bytes(), &resp), "failed to unmarshall response body")
+
+		assert.Equal(code, resp.Code, "unexpected error code for tags %v", tags)
+	}
+}
+
+func (s *Suite) TestPrivateUpload() {
+	s.NoError(s.appCtx.DB.EnsureTagSettings([]storage.TagSettings{{Tag: "evidence", Private: true}}))
+
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"tags": "evidence", "key": "private"}, "file", path)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var resp struct {
+		Error   string
+		Payload uploadResponsePayload
+	}
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	s.True(resp.Payload.Private)
+	s.NotNil(resp.Payload.URLsExpire)
+
+	img, err := s.appCtx.DB.QueryImageByKey("private")
+	s.NoError(err)
+	s.True(img.Private)
+
+	public, err := http.Get(img.URL)
+	s.NoError(err)
+	public.Body.Close()
+	s.Equal(http.StatusForbidden, public.StatusCode, "stored url should not be public")
+
+	signed, err := http.Get(resp.Payload.OriginalURL)
+	s.NoError(err)
+	signed.Body.Close()
+	s.Equal(http.StatusOK, signed.StatusCode, "presigned url should be available")
+
+	request, err = http.NewRequest("GET", "http://localhost:8000/images/private/urls", nil)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	s.True(strings.Contains(resp.Payload.OriginalURL, "X-Amz-Signature"))
+}
+
+func (s *Suite) TestRealStorageClass() {
+	s.NoError(s.appCtx.DB.EnsureTagSettings([]storage.TagSettings{{Tag: "catalog", RealStorageClass: "STANDARD_IA"}}))
+
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"tags": "catalog", "key": "infrequent"}, "file", path)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	img, err := s.appCtx.DB.QueryImageByKey("infrequent")
+	s.NoError(err)
+	s.Equal("", img.Storage, "image should be stored in default storage")
+	s.Equal("STANDARD_IA", img.RealStorageClass)
+
+	s.NoError(s.appCtx.ImageService.Archive("infrequent"))
+	s.NoError(s.appCtx.ImageService.Restore("infrequent"), "image should be restored from real copy")
+}
+
+func (s *Suite) TestReplicaRestore() {
+	root, err := ioutil.TempDir("", "louis-replica")
+	s.NoError(err)
+	defer os.RemoveAll(root)
+	s.appCtx.Replica, err = storage.NewFileStore(root)
+	s.NoError(err)
+	defer func() { s.appCtx.Replica = nil }()
+
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"key": "replicated"}, "file", path)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	s.NoError(s.appCtx.ImageService.Replicate("replicated"))
+	img, err := s.appCtx.DB.QueryImageByKey("replicated")
+	s.NoError(err)
+	s.Equal(storage.ReplicaDone, img.ReplicaStatus)
+	s.FileExists(filepath.Join(root, "replicated", "real.jpg"))
+
+	s.NoError(s.appCtx.ImageService.Archive("replicated"))
+	s.NoError(s.appCtx.Storage.DeleteObject("replicated/real.jpg"))
+	s.NoError(s.appCtx.ImageService.Restore("replicated"), "image should be restored from replica")
+
+	_, err = s.appCtx.Storage.GetObject("replicated/real.jpg")
+	s.NoError(err, "real copy should be uploaded back")
+}
+
 func (s *Suite) TestClaim() {
 
 	assert := assert.New(s.T())
@@ -335,6 +555,440 @@ func (s *Suite) TestClaim() {
 
 }
 
+func (s *Suite) TestGetImage() {
+	var set = storage.TransformationSet{
+		Name:      "card",
+		Tag:       "card",
+		Type:      "fit",
+		Width:     60,
+		Height:    80,
+		Quality:   80,
+		Densities: []int{1, 2},
+		Sizes:     "60px",
+	}
+	s.NoError(s.appCtx.DB.EnsureTransformations(set.Variants()))
+
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"tags": "card"}, "file", path)
+	s.NoError(err)
+
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var uploadResp responseTemplate
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &uploadResp))
+	var uploadPayload = uploadResp.Payload.(map[string]interface{})
+	s.NotNil(uploadPayload["sets"])
+
+	request, err = http.NewRequest("GET", "http://localhost:8000/images/"+uploadPayload["key"].(string), nil)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var resp responseTemplate
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	var payload = resp.Payload.(map[string]interface{})
+
+	s.Equal(uploadPayload["originalUrl"], payload["originalUrl"])
+	s.Equal(uploadPayload["transformations"], payload["transformations"])
+
+	var card = payload["sets"].(map[string]interface{})["card"].(map[string]interface{})
+	s.Equal("60px", card["sizes"])
+	s.Regexp("^http.*card_1x\\.jpg 1x, http.*card_2x\\.jpg 2x$", card["srcset"])
+}
+
+func (s *Suite) TestReplace() {
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"tags": "cover_wide"}, "file", path)
+	s.NoError(err)
+
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var uploadResp responseTemplate
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &uploadResp))
+	var uploadPayload = uploadResp.Payload.(map[string]interface{})
+	var key = uploadPayload["key"].(string)
+
+	request, err = newFileUploadRequest("http://localhost:8000/images/"+key, nil, "file", path)
+	s.NoError(err)
+	request.Method = "PUT"
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var resp responseTemplate
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	var payload = resp.Payload.(map[string]interface{})
+	s.Equal(key, payload["key"])
+	s.Equal(float64(1), payload["version"])
+	s.NotEqual(uploadPayload["originalUrl"], payload["originalUrl"])
+	s.Contains(payload["originalUrl"], key+"/v1/original.jpg")
+
+	img, err := s.appCtx.DB.QueryImageByKey(key)
+	s.NoError(err)
+	s.Equal(1, img.Version)
+	s.Equal(payload["originalUrl"], img.URL)
+
+	// replacement of version which is already replaced is rejected
+	image, err := ioutil.ReadFile(path)
+	s.NoError(err)
+	img.Version = 0
+	_, err = s.appCtx.ImageService.Replace(img, transformations.TransformParams{Image: image})
+	s.Equal(storage.ErrVersionConflict, err)
+	img, err = s.appCtx.DB.QueryImageByKey(key)
+	s.NoError(err)
+	s.Equal(1, img.Version)
+
+	// while next version is uploaded, image is served from current one and other replacements are rejected
+	s.NoError(s.appCtx.DB.ReserveImageVersion(key, 1, time.Now().Add(-ReplaceReservationTTL)))
+	request, err = http.NewRequest("GET", "http://localhost:8000/images/"+key, nil)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	var metadata = resp.Payload.(map[string]interface{})
+	s.Equal(float64(1), metadata["version"])
+	s.Equal(payload["originalUrl"], metadata["originalUrl"])
+
+	request, err = newFileUploadRequest("http://localhost:8000/images/"+key, nil, "file", path)
+	s.NoError(err)
+	request.Method = "PUT"
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusConflict, response.Code, "should respond with 409")
+
+	s.NoError(s.appCtx.DB.ReleaseImageVersion(key, 1))
+	img, err = s.appCtx.DB.QueryImageByKey(key)
+	s.NoError(err)
+	s.Equal(1, img.Version)
+	s.Equal(0, img.ReplacingVersion)
+
+	request, err = newFileUploadRequest("http://localhost:8000/images/unknown", nil, "file", path)
+	s.NoError(err)
+	request.Method = "PUT"
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusNotFound, response.Code, "should respond with 404")
+}
+
+func (s *Suite) TestSimilar() {
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	var keys []string
+	for i := 0; i < 2; i++ {
+		request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", nil, "file", path)
+		s.NoError(err)
+		request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+		response := httptest.NewRecorder()
+		s.server.appRouter.ServeHTTP(response, request)
+		s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+		var resp responseTemplate
+		s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+		keys = append(keys, resp.Payload.(map[string]interface{})["key"].(string))
+	}
+
+	request, err := http.NewRequest("GET", "http://localhost:8000/images/"+keys[0]+"/similar?distance=2", nil)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var resp responseTemplate
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	var images = resp.Payload.(map[string]interface{})["images"].([]interface{})
+	s.Len(images, 1)
+	s.Equal(keys[1], images[0].(map[string]interface{})["key"])
+	s.Equal(float64(0), images[0].(map[string]interface{})["distance"])
+
+	request, err = newFileUploadRequest("http://localhost:8000/search/similar", nil, "file", path)
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	s.Len(resp.Payload.(map[string]interface{})["images"], 2)
+}
+
+func (s *Suite) TestBatchUpload() {
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	image, err := ioutil.ReadFile(path)
+	s.NoError(err)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, data := range [][]byte{image, []byte("not an image"), image} {
+		part, err := writer.CreateFormFile("file", "picture.jpg")
+		s.NoError(err)
+		_, err = part.Write(data)
+		s.NoError(err)
+	}
+	for _, key := range []string{"batch_first", "batch_broken", "batch_third"} {
+		s.NoError(writer.WriteField("key", key))
+	}
+	s.NoError(writer.WriteField("tags", "cover_wide"))
+	s.NoError(writer.Close())
+
+	request, err := http.NewRequest("POST", "http://localhost:8000/uploadWithClaim", body)
+	s.NoError(err)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var resp struct {
+		Error   string
+		Payload []batchItemResult
+	}
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	s.Len(resp.Payload, 3)
+
+	s.Equal(http.StatusOK, resp.Payload[0].Status)
+	s.Equal(http.StatusBadRequest, resp.Payload[1].Status)
+	s.Equal(probe.CodeInvalidImage, resp.Payload[1].Code)
+	s.Equal(http.StatusOK, resp.Payload[2].Status)
+
+	for _, key := range []string{"batch_first", "batch_third"} {
+		img, err := s.appCtx.DB.QueryImageByKey(key)
+		s.NoError(err)
+		s.True(img.Approved)
+		s.Equal([]string{"cover_wide"}, []string(img.Tags))
+	}
+	_, err = s.appCtx.DB.QueryImageByKey("batch_broken")
+	s.Error(err)
+}
+
+func (s *Suite) TestResumableUpload() {
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	image, err := ioutil.ReadFile(path)
+	s.NoError(err)
+
+	var send = func(method, uri string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(method, "http://localhost:8000"+uri, bytes.NewReader(body))
+		s.NoError(err)
+		request.Header.Set("Authorization", s.appCtx.Config.SecretKey)
+		request.Header.Set("Tus-Resumable", TusVersion)
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		response := httptest.NewRecorder()
+		s.server.appRouter.ServeHTTP(response, request)
+		return response
+	}
+
+	response := send("POST", "/files", nil, map[string]string{
+		"Upload-Length":   fmt.Sprint(len(image)),
+		"Upload-Metadata": "key " + base64.StdEncoding.EncodeToString([]byte("resumable")),
+	})
+	s.Equal(http.StatusCreated, response.Code, "should respond with 201 Created")
+	var location = response.Header().Get("Location")
+	s.True(strings.HasPrefix(location, "/files/"))
+
+	var half = len(image) / 2
+	var chunk = map[string]string{"Content-Type": offsetContentType, "Upload-Offset": "0"}
+	response = send("PATCH", location, image[:half], chunk)
+	s.Equal(http.StatusNoContent, response.Code)
+	s.Equal(fmt.Sprint(half), response.Header().Get("Upload-Offset"))
+
+	response = send("PATCH", location, image[half:], chunk)
+	s.Equal(http.StatusConflict, response.Code, "should reject chunk with wrong offset")
+
+	response = send("HEAD", location, nil, nil)
+	s.Equal(http.StatusOK, response.Code)
+	s.Equal(fmt.Sprint(half), response.Header().Get("Upload-Offset"))
+
+	chunk["Upload-Offset"] = fmt.Sprint(half)
+	tx, _, err := s.appCtx.DB.LockResumableUpload(strings.TrimPrefix(location, "/files/"))
+	s.NoError(err)
+	response = send("PATCH", location, image[half:], chunk)
+	s.Equal(http.StatusLocked, response.Code, "should reject chunk while upload is written by another request")
+	var expire = &work.Job{Name: ExpireUploadTask, Args: map[string]interface{}{"id": strings.TrimPrefix(location, "/files/")}}
+	s.Error((&CleanupTaskCtx{AppContext: s.appCtx}).ExpireUpload(expire), "should not expire upload while it is written")
+	tx.Rollback()
+
+	response = send("PATCH", location, image[half:], chunk)
+	s.Equal(http.StatusNoContent, response.Code)
+	s.Equal("resumable", response.Header().Get(ImageKeyHeader))
+
+	img, err := s.appCtx.DB.QueryImageByKey("resumable")
+	s.NoError(err)
+	s.True(img.Approved)
+
+	response = send("HEAD", location, nil, nil)
+	s.Equal(storage.UploadDone, response.Header().Get(UploadStatusHeader))
+	s.Equal("resumable", response.Header().Get(ImageKeyHeader))
+
+	response = send("DELETE", location, nil, nil)
+	s.Equal(http.StatusNoContent, response.Code)
+	response = send("HEAD", location, nil, nil)
+	s.Equal(http.StatusNotFound, response.Code)
+}
+
+func (s *Suite) TestDirectUpload() {
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	image, err := ioutil.ReadFile(path)
+	s.NoError(err)
+
+	request, err := newClaimRequest("http://localhost:8000/upload/direct", map[string]interface{}{"key": "direct", "tags": []string{"cover_wide"}})
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var resp struct {
+		Error   string
+		Payload directUploadPayload
+	}
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	s.Equal("direct", resp.Payload.ImageKey)
+
+	var complete = func() *httptest.ResponseRecorder {
+		request, err := http.NewRequest("POST", "http://localhost:8000/upload/direct/"+resp.Payload.ID+"/complete", nil)
+		s.NoError(err)
+		request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+		response := httptest.NewRecorder()
+		s.server.appRouter.ServeHTTP(response, request)
+		return response
+	}
+	s.Equal(http.StatusConflict, complete().Code, "should not complete before image is uploaded")
+
+	request, err = http.NewRequest("PUT", resp.Payload.UploadURL, bytes.NewReader(image))
+	s.NoError(err)
+	put, err := http.DefaultClient.Do(request)
+	s.NoError(err)
+	put.Body.Close()
+	s.Equal(http.StatusOK, put.StatusCode)
+
+	// upload which is processed by another request is not completed again
+	s.NoError(s.appCtx.DB.StartDirectUpload(resp.Payload.ID))
+	s.Equal(http.StatusLocked, complete().Code, "should not complete upload processed by another request")
+	s.NoError(s.appCtx.DB.SetDirectUploadStatus(resp.Payload.ID, storage.UploadPending, "", ""))
+
+	response = complete()
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	img, err := s.appCtx.DB.QueryImageByKey("direct")
+	s.NoError(err)
+	s.True(img.Approved)
+	s.Equal([]string{"cover_wide"}, []string(img.Tags))
+
+	_, err = s.appCtx.Storage.ObjectSize(s.appCtx.stagingKey(resp.Payload.ID))
+	s.Equal(storage.NoSuchKeyError, err, "staged object should be deleted")
+	s.Equal(http.StatusConflict, complete().Code, "should not complete twice")
+}
+
+func (s *Suite) TestAnimatedRealContentType() {
+	var animation = &gif.GIF{Delay: []int{10, 10}}
+	for _, c := range []color.Color{color.White, color.Black} {
+		var frame = image.NewPaletted(image.Rect(0, 0, 100, 100), color.Palette{color.White, color.Black})
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		animation.Image = append(animation.Image, frame)
+	}
+	file, err := ioutil.TempFile("", "animated")
+	s.NoError(err)
+	defer os.Remove(file.Name())
+	s.NoError(gif.EncodeAll(file, animation))
+	s.NoError(file.Close())
+
+	request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"key": "animated_real"}, "file", file.Name())
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	img, err := s.appCtx.DB.QueryImageByKey("animated_real")
+	s.NoError(err)
+	s.Equal(2, img.Frames)
+	// "real" copy keeps its name, but it is stored with content type of animation
+	contentType, err := s.appCtx.Storage.ObjectContentType(makePath(RealTransformName, ObjectFolder(img), ImageExtension), nil)
+	s.NoError(err)
+	s.Equal("image/gif", contentType)
+	contentType, err = s.appCtx.Storage.ObjectContentType(makePath(OriginalTransformName, ObjectFolder(img), ImageExtension), nil)
+	s.NoError(err)
+	s.Equal("image/jpeg", contentType)
+}
+
+func (s *Suite) TestDerive() {
+	path, _ := os.Getwd()
+	path = filepath.Join(path, "../../../test/data/picture.jpg")
+	request, err := newFileUploadRequest("http://localhost:8000/uploadWithClaim", map[string]string{"tags": "cover_wide"}, "file", path)
+	s.NoError(err)
+
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response := httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var uploadResp responseTemplate
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &uploadResp))
+	var parentKey = uploadResp.Payload.(map[string]interface{})["key"].(string)
+
+	request, err = newClaimRequest("http://localhost:8000/images/"+parentKey+"/derive", map[string]interface{}{
+		"crop":   map[string]int{"x": 0, "y": 0, "width": 100, "height": 50},
+		"rotate": 90,
+		"flip":   "horizontal",
+	})
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusOK, response.Code, "should respond with 200 OK")
+
+	var resp responseTemplate
+	s.NoError(json.Unmarshal(response.Body.Bytes(), &resp))
+	var key = resp.Payload.(map[string]interface{})["key"].(string)
+	s.NotEqual(parentKey, key)
+
+	img, err := s.appCtx.DB.QueryImageByKey(key)
+	s.NoError(err)
+	s.Equal(parentKey, img.ParentKey)
+	s.Equal([]string{"cover_wide"}, []string(img.Tags))
+	s.True(img.Approved)
+
+	request, err = newClaimRequest("http://localhost:8000/images/"+parentKey+"/derive", map[string]interface{}{"rotate": 45})
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusBadRequest, response.Code, "should reject invalid angle")
+
+	s.appCtx.Config.MinImageWidth = 60
+	defer func() { s.appCtx.Config.MinImageWidth = 0 }()
+	request, err = newClaimRequest("http://localhost:8000/images/"+parentKey+"/derive", map[string]interface{}{
+		"crop":   map[string]int{"x": 0, "y": 0, "width": 100, "height": 50},
+		"rotate": 90,
+	})
+	s.NoError(err)
+	request.Header.Add("Authorization", s.appCtx.Config.SecretKey)
+	response = httptest.NewRecorder()
+	s.server.appRouter.ServeHTTP(response, request)
+	s.Equal(http.StatusBadRequest, response.Code, "should reject derived image narrower than minimum width")
+	s.Contains(response.Body.String(), probe.CodeTooNarrow)
+}
+
 func ensureTransformations(t *testing.T, appCtx *AppContext, resp responseTemplate) {
 	var payload = resp.Payload.(map[string]interface{})
 
@@ -1,12 +1,22 @@
 package louis
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	_ "image/jpeg"
-	_ "image/png"
+	"github.com/KazanExpress/louis/internal/pkg/imagemeta"
+	"github.com/KazanExpress/louis/internal/pkg/placeholder"
+	"github.com/KazanExpress/louis/internal/pkg/publicurl"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/transformations"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"log"
 	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
@@ -23,6 +33,8 @@ const (
 	// to add "real" transform, which uploads image as it is
 	RealTransformName = "real"
 	ImageExtension    = "jpg"
+	// "poster" transform is first frame of animated image
+	PosterTransformName = "poster"
 )
 
 type imageData struct {
@@ -31,14 +43,44 @@ type imageData struct {
 }
 
 type responseTemplate struct {
-	Error   string      `json:"error"`
+	Error string `json:"error"`
+	// Code - machine readable reason of error, set only for rejected uploads
+	Code    string      `json:"code,omitempty"`
 	Payload interface{} `json:"payload"`
 }
 
 type uploadResponsePayload struct {
-	ImageKey        string            `json:"key"`
-	OriginalURL     string            `json:"originalUrl"`
-	Transformations map[string]string `json:"transformations"`
+	ImageKey string `json:"key"`
+	Version  int    `json:"version,omitempty"`
+	// DuplicateOf - key of existing image with the same content, set if upload is deduplicated
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+	// Private - if image objects are not public, URLs are presigned and expire at URLsExpire
+	Private         bool                     `json:"private,omitempty"`
+	URLsExpire      *time.Time               `json:"urlsExpire,omitempty"`
+	OriginalURL     string                   `json:"originalUrl"`
+	Transformations map[string]string        `json:"transformations"`
+	Sets            map[string]srcSet        `json:"sets,omitempty"`
+	Placeholder     *placeholder.Placeholder `json:"placeholder,omitempty"`
+}
+
+// srcSet - ready to use values of img "srcset" and "sizes" attributes
+type srcSet struct {
+	SrcSet string `json:"srcset"`
+	Sizes  string `json:"sizes"`
+}
+
+type imageMetadataPayload struct {
+	uploadResponsePayload
+	Tags       []string  `json:"tags"`
+	Approved   bool      `json:"approved"`
+	Deleted    bool      `json:"deleted"`
+	CreateDate time.Time `json:"createDate"`
+	// ParentKey - key of image which this one is derived from
+	ParentKey string `json:"parentKey,omitempty"`
+	// PerceptualHash - hex encoded dHash of image
+	PerceptualHash string `json:"perceptualHash,omitempty"`
+	// Metadata - EXIF and IPTC fields of source image
+	Metadata *imagemeta.Metadata `json:"metadata,omitempty"`
 }
 
 func failOnError(w http.ResponseWriter, err error, logMessage string, code int) (failed bool) {
@@ -52,25 +94,195 @@ func failOnError(w http.ResponseWriter, err error, logMessage string, code int)
 	return false
 }
 
-func makeTransformsPayload(imgKey string, transformationsURLs map[string]string) uploadResponsePayload {
+func makeTransformsPayload(imgKey string, results *UploadResults) uploadResponsePayload {
 	return uploadResponsePayload{
 		ImageKey:        imgKey,
-		OriginalURL:     transformationsURLs[OriginalTransformName],
-		Transformations: transformationsURLs,
+		Version:         results.Version,
+		OriginalURL:     results.TransformURLs[OriginalTransformName],
+		Transformations: results.TransformURLs,
+		Sets:            makeSrcSets(results.Transformations, results.TransformURLs),
+		Placeholder:     results.Placeholder,
+		Private:         results.Private,
+	}
+}
+
+// makeImageMetadataPayload - makes payload of stored image, URLs are built by template if it is enabled,
+// otherwise they are derived from stored URL of "original" transformation
+func makeImageMetadataPayload(img *storage.Image, trans []storage.Transformation, tmpl *publicurl.Template) imageMetadataPayload {
+	var urls = make(map[string]string)
+	if img.URL != "" && !img.Deleted {
+		// all transforms are stored next to "original" one
+		var baseURL = strings.TrimSuffix(img.URL, OriginalTransformName+"."+ImageExtension)
+		var makeURL = func(name, extension string) string {
+			if tmpl.Enabled() {
+				return tmpl.URL(img.Tags, ObjectFolder(img), name, extension)
+			}
+			return baseURL + name + "." + extension
+		}
+		urls[OriginalTransformName] = makeURL(OriginalTransformName, ImageExtension)
+		for i := range trans {
+			var extension, _ = transformFormat(&trans[i], img.Animated)
+			urls[trans[i].Name] = makeURL(trans[i].Name, extension)
+		}
+		if img.Frames > 1 {
+			urls[PosterTransformName] = makeURL(PosterTransformName, ImageExtension)
+		}
+	}
+	return imageMetadataPayload{
+		uploadResponsePayload: uploadResponsePayload{
+			ImageKey:        img.Key,
+			Version:         img.Version,
+			OriginalURL:     urls[OriginalTransformName],
+			Transformations: urls,
+			Sets:            makeSrcSets(trans, urls),
+			Placeholder:     imagePlaceholder(img),
+			Private:         img.Private,
+		},
+		Tags:           img.Tags,
+		Approved:       img.Approved,
+		Deleted:        img.Deleted,
+		CreateDate:     img.CreateDate,
+		ParentKey:      img.ParentKey,
+		PerceptualHash: perceptualHash(img),
+		Metadata:       img.Metadata,
+	}
+}
+
+func perceptualHash(img *storage.Image) string {
+	if img.PerceptualHash == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%016x", uint64(img.PerceptualHash))
+}
+
+func imagePlaceholder(img *storage.Image) *placeholder.Placeholder {
+	if img.BlurHash == "" {
+		return nil
+	}
+	return &placeholder.Placeholder{
+		BlurHash:      img.BlurHash,
+		DominantColor: img.DominantColor,
+		Preview:       img.Preview,
+	}
+}
+
+// makeSrcSets - groups uploaded variants of transformation sets into srcset strings
+func makeSrcSets(trans []storage.Transformation, urls map[string]string) map[string]srcSet {
+	var variants = make(map[string][]storage.Transformation)
+	for _, tr := range trans {
+		if _, uploaded := urls[tr.Name]; tr.Set != "" && uploaded {
+			variants[tr.Set] = append(variants[tr.Set], tr)
+		}
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+
+	var sets = make(map[string]srcSet, len(variants))
+	for name, list := range variants {
+		sort.Slice(list, func(i, j int) bool { return list[i].Density < list[j].Density })
+		var candidates = make([]string, len(list))
+		for i, tr := range list {
+			candidates[i] = fmt.Sprintf("%s %dx", urls[tr.Name], tr.Density)
+		}
+		sets[name] = srcSet{
+			SrcSet: strings.Join(candidates, ", "),
+			Sizes:  list[0].Sizes,
+		}
+	}
+	return sets
+}
+
+func makePath(transformName, imageKey, extension string) string {
+	return fmt.Sprintf("%s/%s.%s", imageKey, transformName, extension)
+}
+
+// contentHash - returns hex encoded sha256 of file, crop points are hashed with it,
+// so the same file cropped differently is not a duplicate
+func contentHash(image ImageBuffer, crop *utils.Square) string {
+	var hash = sha256.New()
+	hash.Write(image)
+	if crop != nil {
+		fmt.Fprintf(hash, "\ncrop:%d,%d,%d,%d", crop.TopLeftPoint.X, crop.TopLeftPoint.Y, crop.BottomRightPoint.X, crop.BottomRightPoint.Y)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// versionPrefix - returns folder of image version, first version is stored right in image folder
+func versionPrefix(folder string, version int) string {
+	if version == 0 {
+		return folder
+	}
+	return fmt.Sprintf("%s/v%d", folder, version)
+}
+
+// imageFolder - returns folder of image objects, images uploaded before key layouts are stored under key
+func imageFolder(img *storage.Image) string {
+	if img.Folder == "" {
+		return img.Key
+	}
+	return img.Folder
+}
+
+// ObjectFolder - returns folder of objects of current image version
+func ObjectFolder(img *storage.Image) string {
+	return versionPrefix(imageFolder(img), img.Version)
+}
+
+// transformFormat - returns file extension and content type of transformation result,
+// animated tells if transformation is applied to image which keeps animation
+func transformFormat(trans *storage.Transformation, animated bool) (string, string) {
+	var format = trans.Format()
+	if animated && trans.KeepAnimation {
+		format = transformations.AnimationFormat(trans)
 	}
+	switch format {
+	case "gif", "png", "webp":
+		return format, contentType(format)
+	}
+	return ImageExtension, contentType(ImageExtension)
 }
 
-func makePath(transformName, imageKey string) string {
-	return fmt.Sprintf("%s/%s.%s", imageKey, transformName, ImageExtension)
+// contentType - returns content type of object with extension of one of output formats
+func contentType(extension string) string {
+	switch extension {
+	case "gif":
+		return "image/gif"
+	case "png":
+		return "image/png"
+	case "webp":
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+// objectTransformation - returns transformation which produced object and extension of object,
+// transformation is found by file name, unknown ones get only name
+func objectTransformation(byName map[string]storage.Transformation, objectKey string) (storage.Transformation, string) {
+	var base = path.Base(objectKey)
+	var extension = strings.TrimPrefix(path.Ext(base), ".")
+	var name = strings.TrimSuffix(base, path.Ext(base))
+	if trans, ok := byName[name]; ok {
+		return trans, extension
+	}
+	return storage.Transformation{Name: name}, extension
 }
 
 func respondWithJSON(w http.ResponseWriter, err string, payload interface{}, code int) error {
-	response := responseTemplate{Error: err, Payload: payload}
+	return respond(w, responseTemplate{Error: err, Payload: payload}, code)
+}
+
+// respondWithErrorCode - responds with error which has machine readable code
+func respondWithErrorCode(w http.ResponseWriter, errorCode, err string, code int) error {
+	return respond(w, responseTemplate{Error: err, Code: errorCode}, code)
+}
+
+func respond(w http.ResponseWriter, response responseTemplate, code int) error {
 	jsonResponse, merror := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
 
 	if merror != nil {
-		log.Printf("ERROR: some shit happened on wrapping response to json. payload: %v", payload)
+		log.Printf("ERROR: some shit happened on wrapping response to json. payload: %v", response.Payload)
 		http.Error(w, "Failed to construct response", http.StatusInternalServerError)
 		return merror
 	}
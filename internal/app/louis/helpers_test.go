@@ -0,0 +1,62 @@
+package louis
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/publicurl"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMakeImageMetadataPayloadURLs(t *testing.T) {
+	assert := assert.New(t)
+
+	var img = &storage.Image{
+		Key:     "abc",
+		Version: 1,
+		URL:     "https://bucket.s3.example.com/abc/v1/original.jpg",
+		Tags:    []string{"product"},
+		Frames:  1,
+	}
+	var trans = []storage.Transformation{{Name: "card", Type: "fit"}}
+
+	var payload = makeImageMetadataPayload(img, trans, nil)
+	assert.Equal(img.URL, payload.OriginalURL)
+	assert.Equal("https://bucket.s3.example.com/abc/v1/card.jpg", payload.Transformations["card"])
+
+	tmpl, err := publicurl.New("{cdn}/{key}/{transform}.{ext}", "https://cdn.example.com", []string{"product=https://products.example.com"})
+	assert.NoError(err)
+	payload = makeImageMetadataPayload(img, trans, tmpl)
+	assert.Equal("https://products.example.com/abc/v1/original.jpg", payload.OriginalURL)
+	assert.Equal("https://products.example.com/abc/v1/card.jpg", payload.Transformations["card"])
+}
+
+func TestObjectTransformation(t *testing.T) {
+	assert := assert.New(t)
+
+	var byName = map[string]storage.Transformation{
+		"thumb": {Name: "thumb", CacheControl: "max-age=60"},
+		"real":  realTransformation,
+	}
+
+	trans, extension := objectTransformation(byName, "abc/v1/thumb.webp")
+	assert.Equal("max-age=60", trans.CacheControl)
+	assert.Equal("webp", extension)
+	assert.Equal("image/webp", contentType(extension))
+
+	trans, extension = objectTransformation(byName, "abc/real.jpg")
+	assert.Equal(realTransformation.Type, trans.Type)
+	assert.Equal("image/jpeg", contentType(extension))
+
+	trans, _ = objectTransformation(byName, "abc/removed.jpg")
+	assert.Equal("removed", trans.Name, "unknown transformation should get name of object")
+}
+
+func TestContentHashCrop(t *testing.T) {
+	var image = ImageBuffer("image")
+	var crop = &utils.Square{BottomRightPoint: utils.Point{X: 10, Y: 10}}
+
+	assert.Equal(t, contentHash(image, nil), contentHash(image, nil))
+	assert.Equal(t, contentHash(image, crop), contentHash(image, &utils.Square{BottomRightPoint: utils.Point{X: 10, Y: 10}}))
+	assert.NotEqual(t, contentHash(image, nil), contentHash(image, crop), "cropped upload should not be duplicate of uncropped one")
+}
@@ -0,0 +1,260 @@
+package louis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/gocraft/work"
+	"github.com/gorilla/mux"
+	"github.com/rs/xid"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ImportTask - downloads image of import item and uploads it
+	ImportTask = "import_image"
+
+	ErrCodeFetchFailed    = "fetch_failed"
+	ErrCodeHostNotAllowed = "host_not_allowed"
+	ErrCodeAddrNotAllowed = "address_not_allowed"
+)
+
+type importRequest struct {
+	Items []struct {
+		URL  string   `json:"url"`
+		Key  string   `json:"key"`
+		Tags []string `json:"tags"`
+	} `json:"items"`
+}
+
+type importJobPayload struct {
+	ID    string               `json:"id"`
+	Items []storage.ImportItem `json:"items"`
+}
+
+// hostAllowed - checks host against list of allowed hosts,
+// entry starting with dot allows all subdomains and "*" allows any host
+func hostAllowed(allowed []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if entry == "*" || entry == host || strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImportURL - checks that image can be imported from URL
+func checkImportURL(cfg *utils.Config, rawURL string) error {
+	var u, err = url.Parse(rawURL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return rejectUpload(ErrCodeFetchFailed, "invalid url %q, absolute http(s) url is required", rawURL)
+	}
+	if !hostAllowed(cfg.ImportAllowedHosts, u.Hostname()) {
+		return rejectUpload(ErrCodeHostNotAllowed, "import from host %q is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// privateIP - reports whether ip is loopback, private, link-local (including 169.254.169.254 metadata endpoint),
+// unspecified or multicast address, which should not be reachable by import
+func privateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		// 10/8, 172.16/12, 192.168/16, 100.64/10 (carrier-grade NAT) and 0/8
+		return ip4[0] == 10 || ip4[0] == 172 && ip4[1]&0xf0 == 16 || ip4[0] == 192 && ip4[1] == 168 ||
+			ip4[0] == 100 && ip4[1]&0xc0 == 64 || ip4[0] == 0
+	}
+	// fc00::/7 unique local addresses
+	return len(ip) == net.IPv6len && ip[0]&0xfe == 0xfc
+}
+
+// importDialer - dials only public addresses unless IMPORT_ALLOW_PRIVATE is set,
+// resolved address is dialed directly, so host can not be rebound to private address after the check
+func importDialer(cfg *utils.Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer = &net.Dialer{Timeout: cfg.ImportTimeout}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if cfg.ImportAllowPrivate {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range addrs {
+			if privateIP(ip.IP) {
+				return nil, rejectUpload(ErrCodeAddrNotAllowed, "import from address %v of host %q is not allowed", ip.IP, host)
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no addresses of host %q", host)
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+	}
+}
+
+// fetchImage - downloads image checking host of every redirect, address it resolves to and size of response
+func fetchImage(cfg *utils.Config, rawURL string) (ImageBuffer, error) {
+	if err := checkImportURL(cfg, rawURL); err != nil {
+		return nil, err
+	}
+
+	var client = &http.Client{
+		Timeout: cfg.ImportTimeout,
+		// transport is not shared, so connections are not kept idle after image is read
+		Transport: &http.Transport{DialContext: importDialer(cfg), DisableKeepAlives: true},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.ImportMaxRedirects {
+				return rejectUpload(ErrCodeFetchFailed, "stopped after %v redirects", cfg.ImportMaxRedirects)
+			}
+			return checkImportURL(cfg, req.URL.String())
+		},
+	}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			if rejected, ok := uerr.Err.(*uploadError); ok {
+				return nil, rejected
+			}
+		}
+		return nil, rejectUpload(ErrCodeFetchFailed, "failed to fetch image - %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, rejectUpload(ErrCodeFetchFailed, "source responded with %v", resp.Status)
+	}
+	if resp.ContentLength > cfg.MaxImageSize {
+		return nil, rejectUpload(ErrCodeFileTooLarge, "image size should be less than %v bytes", cfg.MaxImageSize)
+	}
+	image, err := ioutil.ReadAll(io.LimitReader(resp.Body, cfg.MaxImageSize+1))
+	if err != nil {
+		return nil, rejectUpload(ErrCodeFetchFailed, "failed to read image - %v", err)
+	}
+	if int64(len(image)) > cfg.MaxImageSize {
+		return nil, rejectUpload(ErrCodeFileTooLarge, "image size should be less than %v bytes", cfg.MaxImageSize)
+	}
+	return image, nil
+}
+
+// handleImport - creates import job, images are downloaded and uploaded by background workers
+func handleImport(s *session, w http.ResponseWriter, r *http.Request) {
+	if len(s.ctx.Config.ImportAllowedHosts) == 0 {
+		respondWithJSON(w, "import is disabled, IMPORT_ALLOWED_HOSTS is not set", nil, http.StatusForbidden)
+		return
+	}
+
+	var req importRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithJSON(w, "invalid request body: "+err.Error(), nil, http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 || len(req.Items) > s.ctx.Config.ImportMaxItems {
+		respondWithJSON(w, fmt.Sprintf("import should have from 1 to %v items", s.ctx.Config.ImportMaxItems), nil, http.StatusBadRequest)
+		return
+	}
+
+	var job = importJobPayload{ID: xid.New().String(), Items: make([]storage.ImportItem, len(req.Items))}
+	for i, item := range req.Items {
+		if failOnUploadError(w, checkImportURL(s.ctx.Config, item.URL)) {
+			return
+		}
+		for _, tag := range item.Tags {
+			if len(tag) > storage.TagLength {
+				respondWithJSON(w, fmt.Sprintf("tag should not be longer than %v", storage.TagLength), nil, http.StatusBadRequest)
+				return
+			}
+		}
+		if item.Key == "" {
+			item.Key = xid.New().String()
+		}
+		job.Items[i] = storage.ImportItem{
+			JobID:  job.ID,
+			UserID: s.userID,
+			URL:    item.URL,
+			Key:    item.Key,
+			Tags:   item.Tags,
+			Status: storage.ImportPending,
+		}
+	}
+
+	if failOnError(w, s.ctx.DB.CreateImportItems(job.Items), "failed to create import items", http.StatusInternalServerError) {
+		return
+	}
+
+	for i := range job.Items {
+		var _, err = s.ctx.Enqueuer.Enqueue(ImportTask, map[string]interface{}{"id": job.Items[i].ID})
+		if err != nil {
+			log.Printf("ERROR: failed to enqueue import of %v: %v", job.Items[i].URL, err)
+			job.Items[i].Status, job.Items[i].Error = storage.ImportFailed, "failed to enqueue import"
+			_ = s.ctx.DB.SetImportItemStatus(job.Items[i].ID, storage.ImportFailed, "", job.Items[i].Error)
+		}
+	}
+
+	log.Printf("INFO: import job %v with %v items created", job.ID, len(job.Items))
+	respondWithJSON(w, "", job, http.StatusAccepted)
+}
+
+// handleGetImport - returns statuses of items of import job
+func handleGetImport(s *session, w http.ResponseWriter, r *http.Request) {
+	var jobID = mux.Vars(r)["jobId"]
+	var items, err = s.ctx.DB.GetImportJob(jobID, s.userID)
+	if failOnError(w, err, "failed to get import job", http.StatusInternalServerError) {
+		return
+	}
+	if len(items) == 0 {
+		respondWithJSON(w, "import job not found", nil, http.StatusNotFound)
+		return
+	}
+	respondWithJSON(w, "", importJobPayload{ID: jobID, Items: items}, http.StatusOK)
+}
+
+// Import - downloads image of import item and uploads it as claimed image,
+// failure is stored in item and job is not retried
+func (appCtx *CleanupTaskCtx) Import(job *work.Job) error {
+	var id = job.ArgInt64("id")
+	if err := job.ArgError(); err != nil {
+		return err
+	}
+
+	item, err := appCtx.DB.GetImportItem(id)
+	if err != nil {
+		return err
+	}
+	if item.Status != storage.ImportPending {
+		return nil
+	}
+
+	image, err := fetchImage(appCtx.Config, item.URL)
+	if err == nil {
+		_, err = appCtx.processUpload(&requestArgs{image: image, tags: item.Tags, imageKey: item.Key}, item.UserID, true)
+	}
+
+	var status, code, message = storage.ImportDone, "", ""
+	if err != nil {
+		status, message = storage.ImportFailed, err.Error()
+		if uerr, ok := err.(*uploadError); ok {
+			code = uerr.Code
+		}
+		log.Printf("ERROR: failed to import %v: %v", item.URL, err)
+	} else {
+		log.Printf("IMPORT: image with key=%v imported from %v", item.Key, item.URL)
+	}
+	return appCtx.DB.SetImportItemStatus(item.ID, status, code, message)
+}
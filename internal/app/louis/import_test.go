@@ -0,0 +1,98 @@
+package louis
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostAllowed(t *testing.T) {
+	assert := assert.New(t)
+
+	var allowed = []string{"images.example.com", ".cdn.example.com"}
+	assert.True(hostAllowed(allowed, "images.example.com"))
+	assert.True(hostAllowed(allowed, "IMAGES.example.com"))
+	assert.True(hostAllowed(allowed, "eu.cdn.example.com"))
+	assert.False(hostAllowed(allowed, "cdn.example.com"))
+	assert.False(hostAllowed(allowed, "example.com"))
+	assert.False(hostAllowed(nil, "example.com"))
+	assert.True(hostAllowed([]string{"*"}, "example.com"))
+}
+
+func TestFetchImage(t *testing.T) {
+	assert := assert.New(t)
+
+	var image = []byte(strings.Repeat("x", 100))
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/image.jpg", func(w http.ResponseWriter, r *http.Request) { w.Write(image) })
+	mux.HandleFunc("/large.jpg", func(w http.ResponseWriter, r *http.Request) { w.Write(append(image, image...)) })
+	mux.HandleFunc("/missing.jpg", http.NotFound)
+	mux.HandleFunc("/slow.jpg", func(w http.ResponseWriter, r *http.Request) { time.Sleep(200 * time.Millisecond) })
+	mux.Handle("/redirect/1", http.RedirectHandler("/image.jpg", http.StatusFound))
+	mux.Handle("/redirect/2", http.RedirectHandler("/redirect/1", http.StatusFound))
+	mux.Handle("/external", http.RedirectHandler("http://example.com/image.jpg", http.StatusFound))
+	var server = httptest.NewServer(mux)
+	defer server.Close()
+
+	var cfg = &utils.Config{
+		MaxImageSize:       150,
+		ImportAllowedHosts: []string{"127.0.0.1"},
+		ImportTimeout:      100 * time.Millisecond,
+		ImportMaxRedirects: 1,
+		ImportAllowPrivate: true,
+	}
+
+	var code = func(err error) string {
+		if uerr, ok := err.(*uploadError); ok {
+			return uerr.Code
+		}
+		return ""
+	}
+
+	data, err := fetchImage(cfg, server.URL+"/image.jpg")
+	assert.NoError(err)
+	assert.Equal(image, data)
+
+	data, err = fetchImage(cfg, server.URL+"/redirect/1")
+	assert.NoError(err)
+	assert.Equal(image, data)
+
+	_, err = fetchImage(cfg, server.URL+"/redirect/2")
+	assert.Equal(ErrCodeFetchFailed, code(err))
+	_, err = fetchImage(cfg, server.URL+"/external")
+	assert.Equal(ErrCodeHostNotAllowed, code(err))
+	_, err = fetchImage(cfg, server.URL+"/large.jpg")
+	assert.Equal(ErrCodeFileTooLarge, code(err))
+	_, err = fetchImage(cfg, server.URL+"/missing.jpg")
+	assert.Equal(ErrCodeFetchFailed, code(err))
+	_, err = fetchImage(cfg, server.URL+"/slow.jpg")
+	assert.Equal(ErrCodeFetchFailed, code(err))
+	_, err = fetchImage(cfg, "ftp://127.0.0.1/image.jpg")
+	assert.Equal(ErrCodeFetchFailed, code(err))
+	_, err = fetchImage(cfg, "http://localhost/image.jpg")
+	assert.Equal(ErrCodeHostNotAllowed, code(err))
+
+	cfg.ImportAllowPrivate = false
+	_, err = fetchImage(cfg, server.URL+"/image.jpg")
+	assert.Equal(ErrCodeAddrNotAllowed, code(err))
+	cfg.ImportAllowedHosts = []string{"*"}
+	_, err = fetchImage(cfg, "http://169.254.169.254/latest/meta-data/")
+	assert.Equal(ErrCodeAddrNotAllowed, code(err))
+}
+
+func TestPrivateIP(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "172.31.255.255", "192.168.1.1",
+		"169.254.169.254", "100.64.0.1", "0.0.0.0", "224.0.0.1", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1"} {
+		assert.True(privateIP(net.ParseIP(addr)), addr)
+	}
+	for _, addr := range []string{"8.8.8.8", "172.32.0.1", "192.169.0.1", "100.128.0.1", "2001:4860:4860::8888"} {
+		assert.False(privateIP(net.ParseIP(addr)), addr)
+	}
+}
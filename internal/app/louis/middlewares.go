@@ -9,9 +9,9 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"golang.org/x/sync/semaphore"
-	"image"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"strconv"
 	"strings"
@@ -85,8 +85,12 @@ func addAccessControlAllowOriginHeader(cfg *utils.Config) func(http.Handler) htt
 
 func corsMiddleware() mux.MiddlewareFunc {
 	var crs = cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},                      // All origins
-		AllowedMethods: []string{"GET", "POST", "OPTIONS"}, // Allowing only get, post and options
+		AllowedOrigins: []string{"*"}, // All origins
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "HEAD", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-Requested-With",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset"},
+		ExposedHeaders: []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires", ImageKeyHeader, UploadStatusHeader},
 	})
 	return crs.Handler
 }
@@ -106,81 +110,224 @@ func authorize(key string) func(sessionHandler) sessionHandler {
 	}
 }
 
-func validate() func(sessionHandler) sessionHandler {
-
+// authorizeUpload - accepts both keys, images uploaded with secret key are claimed
+func authorizeUpload(cfg *utils.Config) func(sessionHandler) sessionHandler {
 	return func(next sessionHandler) sessionHandler {
 		return sessionHandler(func(s *session, w http.ResponseWriter, r *http.Request) {
-			s.args = new(requestArgs)
-
-			if r.ContentLength > s.ctx.Config.MaxImageSize {
-				respondWithJSON(w, fmt.Sprintf("image size should be less than  %v bytes", s.ctx.Config.MaxImageSize), nil, http.StatusBadRequest)
+			var header = r.Header.Get("Authorization")
+			if header != cfg.PublicKey && header != cfg.SecretKey {
+				respondWithJSON(w, "account not found", nil, http.StatusUnauthorized)
 				return
 			}
+			s.userID = 1
+			s.claim = header == cfg.SecretKey
+			next(s, w, r)
+		})
+	}
+}
 
-			var err = r.ParseMultipartForm(s.ctx.Config.MaxImageSize)
-			if failOnError(w, err, "error on parsing multipart form", http.StatusBadRequest) {
-				return
-			}
+// form fields are sent along with file, so request body may be a bit larger than image
+const multipartOverhead = 1 << 20
 
-			file, _, err := r.FormFile("file")
+// requestError - invalid argument of request, it is responded with its status code
+type requestError struct {
+	message string
+	err     error
+	status  int
+}
 
-			if failOnError(w, err, "error on reading file from multipart", http.StatusBadRequest) {
-				return
-			}
+func (e *requestError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return e.message + ": " + e.err.Error()
+}
 
-			defer file.Close()
+// failOnArgsError - responds with error of parsing request arguments
+func failOnArgsError(w http.ResponseWriter, err error) (failed bool) {
+	if rerr, ok := err.(*requestError); ok {
+		if rerr.err == nil {
+			respondWithJSON(w, rerr.message, nil, rerr.status)
+			return true
+		}
+		return failOnError(w, rerr.err, rerr.message, rerr.status)
+	}
+	return failOnUploadError(w, err)
+}
 
-			var tagsStr = strings.Replace(r.FormValue("tags"), " ", "", -1)
-			if tagsStr != "" {
+func validate() func(sessionHandler) sessionHandler {
+	return validateFiles(1)
+}
 
-				s.args.tags = strings.Split(tagsStr, ",")
-				for _, tag := range s.args.tags {
-					if len(tag) > storage.TagLength {
-						respondWithJSON(w, fmt.Sprintf("tag should not be longer than %v", storage.TagLength), nil, http.StatusBadRequest)
-						return
-					}
-				}
+// validateBatch - validates request which can have up to MAX_BATCH_FILES files,
+// arguments of files are set to session batch, s.args is set only if there is one file
+func validateBatch() func(sessionHandler) sessionHandler {
+	return func(next sessionHandler) sessionHandler {
+		return sessionHandler(func(s *session, w http.ResponseWriter, r *http.Request) {
+			validateFiles(s.ctx.Config.MaxBatchFiles)(next)(s, w, r)
+		})
+	}
+}
+
+func validateFiles(maxFiles int) func(sessionHandler) sessionHandler {
+
+	return func(next sessionHandler) sessionHandler {
+		return sessionHandler(func(s *session, w http.ResponseWriter, r *http.Request) {
+			var maxSize = s.ctx.Config.MaxImageSize * int64(maxFiles)
+
+			if r.ContentLength > maxSize {
+				respondWithErrorCode(w, ErrCodeFileTooLarge, fmt.Sprintf("image size should be less than  %v bytes", s.ctx.Config.MaxImageSize), http.StatusBadRequest)
+				return
 			}
 
-			var buffer bytes.Buffer
-			_, err = io.Copy(&buffer, file)
-			if failOnError(w, err, "failed to copy file to buffer", http.StatusInternalServerError) {
+			// content length is unknown for chunked requests
+			r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+
+			var err = r.ParseMultipartForm(s.ctx.Config.MaxImageSize)
+			if failOnError(w, err, "error on parsing multipart form", http.StatusBadRequest) {
 				return
 			}
-			s.args.image = buffer.Bytes()
 
-			_, _, err = image.Decode(bytes.NewReader(s.args.image))
-			if failOnError(w, err, "error on creating an Image object from bytes", http.StatusBadRequest) {
+			var files = r.MultipartForm.File["file"]
+			if len(files) == 0 {
+				failOnError(w, http.ErrMissingFile, "error on reading file from multipart", http.StatusBadRequest)
+				return
+			}
+			if len(files) > maxFiles {
+				respondWithJSON(w, fmt.Sprintf("at most %v files can be uploaded in one request", maxFiles), nil, http.StatusBadRequest)
 				return
 			}
 
-			var keyArg = r.FormValue("key")
-			if keyArg != "" {
-				s.args.imageKey = keyArg
+			s.batch = make([]batchItem, len(files))
+			for i := range files {
+				s.batch[i].args, s.batch[i].err = s.ctx.parseFileArgs(r.MultipartForm, i, len(files))
 			}
 
-			var cropPoints = r.FormValue("cropPoints")
-			if cropPoints != "" {
-				var values = strings.Split(strings.Trim(cropPoints, " "), ",")
-				if len(values) != 4 {
-					failOnError(w, fmt.Errorf("invalid cropPoints"), "there should be 4 values seprated with comma", http.StatusBadRequest)
+			if len(files) == 1 {
+				if failOnArgsError(w, s.batch[0].err) {
 					return
 				}
-				var iValues = make([]int, 4)
-				for j, val := range values {
-					iVal, err := strconv.ParseInt(strings.Trim(val, " "), 10, 32)
-					if failOnError(w, err, "failed to parse int in cropPoints", http.StatusBadRequest) {
-						return
-					}
-					iValues[j] = int(iVal)
-				}
-				s.args.cropSquare = &utils.Square{
-					TopLeftPoint:     utils.Point{X: iValues[0], Y: iValues[1]},
-					BottomRightPoint: utils.Point{X: iValues[2], Y: iValues[3]},
-				}
+				s.args = s.batch[0].args
 			}
 
 			next(s, w, r)
 		})
 	}
 }
+
+// formValue - returns value of form field for i-th of n files,
+// field should be passed once for all files or once for every file
+func formValue(form *multipart.Form, name string, i, n int) (string, error) {
+	var values = form.Value[name]
+	switch {
+	case len(values) == 0:
+		return "", nil
+	case len(values) == n:
+		return values[i], nil
+	case len(values) == 1 || n == 1:
+		return values[0], nil
+	}
+	return "", &requestError{
+		message: fmt.Sprintf("%q should be passed once or for every file, got %v values for %v files", name, len(values), n),
+		status:  http.StatusBadRequest,
+	}
+}
+
+// uniqueFormValue - returns value of form field for i-th of n files,
+// field can not be shared by files, so it should be passed for every file or not passed at all
+func uniqueFormValue(form *multipart.Form, name string, i, n int) (string, error) {
+	var values = form.Value[name]
+	if len(values) == 0 || len(values) == n {
+		return formValue(form, name, i, n)
+	}
+	return "", &requestError{
+		message: fmt.Sprintf("%q should be passed for every file, got %v values for %v files", name, len(values), n),
+		status:  http.StatusBadRequest,
+	}
+}
+
+// parseFileArgs - reads i-th of n files of multipart form and its arguments
+func (appCtx *AppContext) parseFileArgs(form *multipart.Form, i, n int) (*requestArgs, error) {
+	var args = new(requestArgs)
+
+	var tagsStr, err = formValue(form, "tags", i, n)
+	if err != nil {
+		return nil, err
+	}
+	args.tags, err = parseTags(tagsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := form.File["file"][i].Open()
+	if err != nil {
+		return nil, &requestError{message: "error on reading file from multipart", err: err, status: http.StatusBadRequest}
+	}
+	defer file.Close()
+
+	var buffer bytes.Buffer
+	_, err = io.Copy(&buffer, file)
+	if err != nil {
+		return nil, &requestError{message: "failed to copy file to buffer", err: err, status: http.StatusInternalServerError}
+	}
+	args.image = buffer.Bytes()
+
+	if err = appCtx.checkUpload(args.image, args.tags); err != nil {
+		return nil, err
+	}
+	args.checked = true
+
+	args.imageKey, err = uniqueFormValue(form, "key", i, n)
+	if err != nil {
+		return nil, err
+	}
+
+	cropPoints, err := formValue(form, "cropPoints", i, n)
+	if err != nil {
+		return nil, err
+	}
+	args.cropSquare, err = parseCropPoints(cropPoints)
+	if err != nil {
+		return nil, err
+	}
+	args.contentHash = contentHash(args.image, args.cropSquare)
+	return args, nil
+}
+
+// parseTags - parses comma separated tags
+func parseTags(value string) ([]string, error) {
+	value = strings.Replace(value, " ", "", -1)
+	if value == "" {
+		return nil, nil
+	}
+	var tags = strings.Split(value, ",")
+	for _, tag := range tags {
+		if len(tag) > storage.TagLength {
+			return nil, &requestError{message: fmt.Sprintf("tag should not be longer than %v", storage.TagLength), status: http.StatusBadRequest}
+		}
+	}
+	return tags, nil
+}
+
+// parseCropPoints - parses "x,y,x2,y2" points of crop transformation, empty value means no crop
+func parseCropPoints(value string) (*utils.Square, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var values = strings.Split(strings.Trim(value, " "), ",")
+	if len(values) != 4 {
+		return nil, &requestError{message: "there should be 4 values seprated with comma", err: fmt.Errorf("invalid cropPoints"), status: http.StatusBadRequest}
+	}
+	var iValues = make([]int, 4)
+	for j, val := range values {
+		iVal, err := strconv.ParseInt(strings.Trim(val, " "), 10, 32)
+		if err != nil {
+			return nil, &requestError{message: "failed to parse int in cropPoints", err: err, status: http.StatusBadRequest}
+		}
+		iValues[j] = int(iVal)
+	}
+	return &utils.Square{
+		TopLeftPoint:     utils.Point{X: iValues[0], Y: iValues[1]},
+		BottomRightPoint: utils.Point{X: iValues[2], Y: iValues[3]},
+	}, nil
+}
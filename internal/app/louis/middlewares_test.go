@@ -3,6 +3,7 @@ package louis
 import (
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"github.com/stretchr/testify/assert"
+	"mime/multipart"
 	"testing"
 	"time"
 )
@@ -36,3 +37,58 @@ func TestThrottleuUnlock(t *testing.T) {
 
 	assert.True(throt.lock())
 }
+
+func TestFormValue(t *testing.T) {
+	assert := assert.New(t)
+
+	var form = &multipart.Form{Value: map[string][]string{
+		"tags": {"shop"},
+		"key":  {"first", "", "third"},
+	}}
+
+	for i := 0; i < 3; i++ {
+		tags, err := formValue(form, "tags", i, 3)
+		assert.NoError(err)
+		assert.Equal("shop", tags)
+	}
+
+	key, err := formValue(form, "key", 1, 3)
+	assert.NoError(err)
+	assert.Equal("", key)
+	key, err = formValue(form, "key", 2, 3)
+	assert.NoError(err)
+	assert.Equal("third", key)
+
+	cropPoints, err := formValue(form, "cropPoints", 0, 3)
+	assert.NoError(err)
+	assert.Equal("", cropPoints)
+
+	_, err = formValue(form, "key", 0, 2)
+	assert.Error(err)
+	key, err = formValue(form, "key", 0, 1)
+	assert.NoError(err)
+	assert.Equal("first", key)
+}
+
+func TestUniqueFormValue(t *testing.T) {
+	assert := assert.New(t)
+
+	var form = &multipart.Form{Value: map[string][]string{
+		"key":  {"first", "second"},
+		"tags": {"shop"},
+	}}
+
+	key, err := uniqueFormValue(form, "key", 1, 2)
+	assert.NoError(err)
+	assert.Equal("second", key)
+
+	key, err = uniqueFormValue(form, "cropPoints", 1, 2)
+	assert.NoError(err)
+	assert.Equal("", key)
+
+	_, err = uniqueFormValue(form, "tags", 0, 2)
+	assert.Error(err)
+	key, err = uniqueFormValue(form, "tags", 0, 1)
+	assert.NoError(err)
+	assert.Equal("shop", key)
+}
@@ -0,0 +1,92 @@
+package louis
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/transformations"
+	"github.com/lib/pq"
+	"github.com/rs/xid"
+	"log"
+	"net/http"
+)
+
+// processUpload - upload pipeline shared by all entry points: image is checked, deduplicated by tag settings,
+// transformed and uploaded; claim tells if image should be claimed at once, otherwise it is cleaned up later
+func (appCtx *AppContext) processUpload(args *requestArgs, userID int32, claim bool) (*uploadResponsePayload, error) {
+	if !args.checked {
+		if err := appCtx.checkUpload(args.image, args.tags); err != nil {
+			return nil, err
+		}
+	}
+	if args.contentHash == "" {
+		args.contentHash = contentHash(args.image, args.cropSquare)
+	}
+
+	if args.parentKey == "" {
+		if payload, err := appCtx.deduplicate(args, userID, claim); payload != nil || err != nil {
+			return payload, err
+		}
+	}
+
+	imgID, err := appCtx.createImageRecord(args, userID)
+	if err != nil {
+		return nil, err
+	}
+	if args.parentKey != "" {
+		if err = appCtx.DB.SetImageParent(args.imageKey, args.parentKey); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := appCtx.ImageService.Upload(&UploadArgs{
+		ImageID:     imgID,
+		ImageKey:    args.imageKey,
+		ContentHash: args.contentHash,
+		Params: transformations.TransformParams{
+			Image:      args.image,
+			CropSquare: args.cropSquare,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = appCtx.DB.SetImageURL(args.imageKey, userID, results.TransformURLs[OriginalTransformName]); err != nil {
+		return nil, err
+	}
+	if err = appCtx.claimOrCleanup(args.imageKey, userID, claim); err != nil {
+		return nil, err
+	}
+
+	log.Printf("INFO: image with key %v and %v transforms uploaded, claimed: %v", args.imageKey, len(results.TransformURLs), claim)
+	payload, err := appCtx.transformsPayload(args.imageKey, results)
+	if err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// createImageRecord - adds image with key of args, random key is generated if it is empty
+func (appCtx *AppContext) createImageRecord(args *requestArgs, userID int32) (int64, error) {
+	if args.imageKey == "" {
+		args.imageKey = xid.New().String()
+	}
+	imgID, err := appCtx.DB.AddImage(args.imageKey, userID, args.tags...)
+	if err != nil {
+		if pger, ok := err.(*pq.Error); ok && pger.Constraint == "images_key_key" {
+			return 0, &requestError{message: "image with such key is already exists", status: http.StatusBadRequest}
+		}
+		return 0, err
+	}
+	return imgID, nil
+}
+
+// claimOrCleanup - claims image, or schedules its deletion if it is not claimed in CLEANUP_DELAY
+func (appCtx *AppContext) claimOrCleanup(imageKey string, userID int32, claim bool) error {
+	if claim {
+		return appCtx.DB.SetClaimImage(imageKey, userID)
+	}
+	var _, err = appCtx.Enqueuer.EnqueueUniqueIn(CleanupTask, int64(appCtx.Config.CleanUpDelay*60), map[string]interface{}{"key": imageKey})
+	if err != nil {
+		log.Printf("ERROR: failed to enqueue clean up task: %v", err)
+	}
+	return nil
+}
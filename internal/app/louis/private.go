@@ -0,0 +1,87 @@
+package louis
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"time"
+)
+
+// signURL - returns presigned URL of private object of transformation stored in folder of storage profile
+func (appCtx *AppContext) signURL(profile, folder, transformName, extension string) (string, error) {
+	return appCtx.ImageService.PresignObject(profile, makePath(transformName, folder, extension))
+}
+
+// signPayload - replaces URLs of private image objects stored in folder of storage profile by presigned ones,
+// trans are used to rebuild srcsets and to get extensions of objects, animated tells if image keeps animation
+func (appCtx *AppContext) signPayload(payload *uploadResponsePayload, profile, folder string, trans []storage.Transformation, animated bool) error {
+	var extensions = make(map[string]string, len(trans))
+	for i := range trans {
+		extensions[trans[i].Name], _ = transformFormat(&trans[i], animated)
+	}
+	// expiration is taken before signing, so URLs are valid a bit longer than returned time
+	var expires = time.Now().Add(appCtx.Config.PrivateURLExpiration)
+	var urls = make(map[string]string, len(payload.Transformations))
+	for name := range payload.Transformations {
+		// "original" and "poster" are not in transformations of image and always stored as jpeg
+		var extension, ok = extensions[name]
+		if !ok {
+			extension = ImageExtension
+		}
+		var signed, err = appCtx.signURL(profile, folder, name, extension)
+		if err != nil {
+			return err
+		}
+		urls[name] = signed
+	}
+	payload.Transformations = urls
+	payload.OriginalURL = urls[OriginalTransformName]
+	payload.Sets = makeSrcSets(trans, urls)
+	payload.URLsExpire = &expires
+	return nil
+}
+
+// transformsPayload - makes payload of uploaded image, URLs of private image are presigned
+func (appCtx *AppContext) transformsPayload(imgKey string, results *UploadResults) (uploadResponsePayload, error) {
+	var payload = makeTransformsPayload(imgKey, results)
+	if !results.Private {
+		return payload, nil
+	}
+	return payload, appCtx.signPayload(&payload, results.Storage, results.Folder, results.Transformations, results.Animated)
+}
+
+// imageMetadataPayload - makes payload of stored image, URLs of private image are presigned
+func (appCtx *AppContext) imageMetadataPayload(img *storage.Image, trans []storage.Transformation) (imageMetadataPayload, error) {
+	var payload = makeImageMetadataPayload(img, trans, appCtx.PublicURL)
+	if !img.Private {
+		return payload, nil
+	}
+	return payload, appCtx.signPayload(&payload.uploadResponsePayload, img.Storage, ObjectFolder(img), trans, img.Animated)
+}
+
+// handleImageURLs - returns URLs of image, new presigned URLs are issued for private image
+func handleImageURLs(s *session, w http.ResponseWriter, r *http.Request) {
+	var image, err = s.ctx.DB.QueryImageByKey(mux.Vars(r)["imageKey"])
+	if err == gorm.ErrRecordNotFound {
+		respondWithJSON(w, "image not found", nil, http.StatusNotFound)
+		return
+	}
+	if failOnError(w, err, "failed to get image", http.StatusInternalServerError) {
+		return
+	}
+	if image.Deleted || !image.TransformsUploaded {
+		respondWithJSON(w, "image is not uploaded or archived", nil, http.StatusPreconditionFailed)
+		return
+	}
+
+	trans, err := s.ctx.DB.GetTransformations(image.ID)
+	if failOnError(w, err, "failed to get transformations", http.StatusInternalServerError) {
+		return
+	}
+	payload, err := s.ctx.imageMetadataPayload(image, trans)
+	if failOnError(w, err, "failed to sign urls", http.StatusInternalServerError) {
+		return
+	}
+	respondWithJSON(w, "", payload.uploadResponsePayload, http.StatusOK)
+}
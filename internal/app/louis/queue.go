@@ -12,6 +12,10 @@ import (
 const (
 	CleanupNamespace = "cleanup_pool_namespace"
 	CleanupTask      = "delete_images"
+	// DeleteVersionTask - deletes objects of replaced image version
+	DeleteVersionTask = "delete_image_version"
+	// ReplicateTask - copies "real" object of image to replica store
+	ReplicateTask = "replicate_image"
 )
 
 type CleanupTaskCtx struct {
@@ -45,11 +49,47 @@ func (appCtx *CleanupTaskCtx) Cleanup(job *work.Job) error {
 	return nil
 }
 
+func (appCtx *CleanupTaskCtx) DeleteVersion(job *work.Job) error {
+	var imgKey = job.ArgString("key")
+	var version = int(job.ArgInt64("version"))
+	if err := job.ArgError(); err != nil {
+		return err
+	}
+
+	if err := appCtx.ImageService.DeleteVersion(imgKey, version); err != nil {
+		log.Printf("ERROR: failed to delete version %v of image %v: %v", version, imgKey, err)
+		return err
+	}
+
+	log.Printf("CLEANUP_POOL: version %v of image with key=%v deleted", version, imgKey)
+	return nil
+}
+
+func (appCtx *CleanupTaskCtx) Replicate(job *work.Job) error {
+	var imgKey = job.ArgString("key")
+	if err := job.ArgError(); err != nil {
+		return err
+	}
+
+	if err := appCtx.ImageService.Replicate(imgKey); err != nil {
+		log.Printf("ERROR: failed to replicate image %v: %v", imgKey, err)
+		return err
+	}
+
+	log.Printf("CLEANUP_POOL: image with key=%v replicated", imgKey)
+	return nil
+}
+
 func InitPool(appCtx *AppContext, redisPool *redis.Pool) *work.WorkerPool {
 
 	pool := work.NewWorkerPool(CleanupTaskCtx{}, appCtx.Config.CleanupPoolConcurrency, CleanupNamespace, redisPool)
 
 	pool.Job(CleanupTask, (*CleanupTaskCtx).Cleanup)
+	pool.Job(DeleteVersionTask, (*CleanupTaskCtx).DeleteVersion)
+	pool.Job(ReplicateTask, (*CleanupTaskCtx).Replicate)
+	pool.Job(ExpireUploadTask, (*CleanupTaskCtx).ExpireUpload)
+	pool.Job(ExpireDirectUploadTask, (*CleanupTaskCtx).ExpireDirectUpload)
+	pool.JobWithOptions(ImportTask, work.JobOptions{MaxFails: 1}, (*CleanupTaskCtx).Import)
 
 	pool.Middleware(func(c *CleanupTaskCtx, job *work.Job, next work.NextMiddlewareFunc) error {
 		c.AppContext = appCtx
@@ -56,14 +56,25 @@ func (s *Server) initRoutes() {
 		throttler.Throttle(
 			withSession(s.ctx)(
 				authorize(s.ctx.Config.PublicKey)(
-					validate()(handleUpload)))),
+					validateBatch()(inBatch(handleUpload))))),
 	).Methods("POST")
 
 	s.appRouter.Handle("/uploadWithClaim",
 		throttler.Throttle(
 			withSession(s.ctx)(
 				authorize(s.ctx.Config.SecretKey)(
-					validate()(handleUploadWithClaim)))),
+					validateBatch()(inBatch(handleUploadWithClaim))))),
+	).Methods("POST")
+
+	s.appRouter.HandleFunc("/upload/direct",
+		withSession(s.ctx)(
+			authorizeUpload(s.ctx.Config)(handleCreateDirectUpload),
+		)).Methods("POST")
+
+	s.appRouter.Handle("/upload/direct/{uploadId}/complete",
+		throttler.Throttle(
+			withSession(s.ctx)(
+				authorizeUpload(s.ctx.Config)(handleCompleteDirectUpload))),
 	).Methods("POST")
 
 	s.appRouter.HandleFunc("/claim",
@@ -77,7 +88,79 @@ func (s *Server) initRoutes() {
 				authorize(s.ctx.Config.SecretKey)(handleRestore))),
 	).Methods("POST")
 
+	s.appRouter.HandleFunc("/images/{imageKey}",
+		withSession(s.ctx)(
+			authorize(s.ctx.Config.SecretKey)(handleGetImage),
+		)).Methods("GET")
+
+	s.appRouter.Handle("/images/{imageKey}",
+		throttler.Throttle(
+			withSession(s.ctx)(
+				authorize(s.ctx.Config.SecretKey)(
+					validate()(handleReplace)))),
+	).Methods("PUT")
+
+	s.appRouter.Handle("/images/{imageKey}/derive",
+		throttler.Throttle(
+			withSession(s.ctx)(
+				authorize(s.ctx.Config.SecretKey)(handleDerive))),
+	).Methods("POST")
+
+	s.appRouter.HandleFunc("/images/{imageKey}/urls",
+		withSession(s.ctx)(
+			authorize(s.ctx.Config.SecretKey)(handleImageURLs),
+		)).Methods("GET")
+
+	s.appRouter.HandleFunc("/images/{imageKey}/similar",
+		withSession(s.ctx)(
+			authorize(s.ctx.Config.SecretKey)(handleSimilar),
+		)).Methods("GET")
+
+	s.appRouter.Handle("/search/similar",
+		throttler.Throttle(
+			withSession(s.ctx)(
+				authorize(s.ctx.Config.SecretKey)(
+					validate()(handleSearchSimilar)))),
+	).Methods("POST")
+
+	s.appRouter.HandleFunc("/import",
+		withSession(s.ctx)(
+			authorize(s.ctx.Config.SecretKey)(handleImport),
+		)).Methods("POST")
+
+	s.appRouter.HandleFunc("/import/{jobId}",
+		withSession(s.ctx)(
+			authorize(s.ctx.Config.SecretKey)(handleGetImport),
+		)).Methods("GET")
+
+	var tus = s.appRouter.PathPrefix("/files").Subrouter()
+	tus.Use(tusProtocol)
+
+	tus.HandleFunc("", withSession(s.ctx)(handleTusOptions)).Methods("OPTIONS")
+
+	tus.HandleFunc("",
+		withSession(s.ctx)(
+			authorizeUpload(s.ctx.Config)(handleCreateUpload),
+		)).Methods("POST")
+
+	tus.HandleFunc("/{uploadId}",
+		withSession(s.ctx)(
+			authorizeUpload(s.ctx.Config)(handleUploadOffset),
+		)).Methods("HEAD")
+
+	tus.Handle("/{uploadId}",
+		throttler.Throttle(
+			withSession(s.ctx)(
+				authorizeUpload(s.ctx.Config)(handleUploadChunk))),
+	).Methods("PATCH")
+
+	tus.HandleFunc("/{uploadId}",
+		withSession(s.ctx)(
+			authorizeUpload(s.ctx.Config)(handleTerminateUpload),
+		)).Methods("DELETE")
+
 	s.appRouter.HandleFunc("/healthz", handleHealth).Methods("GET")
+	s.appRouter.HandleFunc("/capabilities", handleCapabilities).Methods("GET")
 
 	s.metricsRouter.Handle("/metrics", promhttp.Handler())
 	s.metricsRouter.HandleFunc("/free", handleFree).Methods("POST")
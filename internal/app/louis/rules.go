@@ -0,0 +1,128 @@
+package louis
+
+import (
+	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/probe"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/KazanExpress/louis/internal/pkg/transformations"
+	"log"
+	"net/http"
+)
+
+// Error codes of rejected uploads, codes of format and dimension checks are defined in probe package
+const (
+	ErrCodeFileTooLarge     = "file_too_large"
+	ErrCodeFormatNotAllowed = "format_not_allowed"
+	ErrCodeTooManyTags      = "too_many_tags"
+	ErrCodeMissingTag       = "missing_required_tag"
+)
+
+// uploadError - reason why upload is rejected
+type uploadError struct {
+	Code    string
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+func rejectUpload(code, format string, args ...interface{}) *uploadError {
+	return &uploadError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// failOnUploadError - responds with 400 and error code if upload is rejected, or with 500 on other errors
+func failOnUploadError(w http.ResponseWriter, err error) (failed bool) {
+	if uerr, ok := err.(*uploadError); ok {
+		log.Printf("INFO: upload rejected - %v", uerr)
+		respondWithErrorCode(w, uerr.Code, uerr.Message, http.StatusBadRequest)
+		return true
+	}
+	return failOnError(w, err, "failed to check upload", http.StatusInternalServerError)
+}
+
+// checkUpload - checks image and its tags against global limits and settings of tags,
+// format and dimensions are read from image header without decoding
+func (appCtx *AppContext) checkUpload(image ImageBuffer, tags []string) error {
+	var settings, err = appCtx.DB.GetTagSettings(tags)
+	if err != nil {
+		return err
+	}
+
+	var maxFileSize = appCtx.Config.MaxImageSize
+	var limits = probe.Limits{
+		MaxPixels: appCtx.Config.MaxImagePixels,
+		MaxWidth:  appCtx.Config.MaxImageWidth,
+		MaxHeight: appCtx.Config.MaxImageHeight,
+		MinWidth:  appCtx.Config.MinImageWidth,
+		MinHeight: appCtx.Config.MinImageHeight,
+	}
+	for _, ts := range settings {
+		if err := checkTags(&ts, tags); err != nil {
+			return err
+		}
+		if ts.MaxFileSize > 0 && ts.MaxFileSize < maxFileSize {
+			maxFileSize = ts.MaxFileSize
+		}
+		limits = limits.Merge(probe.Limits{
+			MaxPixels: ts.MaxPixels,
+			MaxWidth:  ts.MaxWidth,
+			MaxHeight: ts.MaxHeight,
+			MinWidth:  ts.MinWidth,
+			MinHeight: ts.MinHeight,
+			MinAspect: storage.AspectRatio(ts.MinAspect),
+			MaxAspect: storage.AspectRatio(ts.MaxAspect),
+		})
+	}
+
+	if int64(len(image)) > maxFileSize {
+		return rejectUpload(ErrCodeFileTooLarge, "image size should be less than %v bytes", maxFileSize)
+	}
+
+	info, err := probe.Probe(image)
+	if err != nil {
+		return fromProbeError(err)
+	}
+	if !transformations.IsInputSupported(info.Format) {
+		return rejectUpload(probe.CodeUnsupportedFormat, "%v images are not supported", info.Format)
+	}
+	for _, ts := range settings {
+		if len(ts.Formats) > 0 && !contains(ts.Formats, info.Format) {
+			return rejectUpload(ErrCodeFormatNotAllowed, "%v images are not allowed for tag %q, allowed formats are %v", info.Format, ts.Tag, ts.Formats)
+		}
+	}
+
+	if err = limits.Check(info); err != nil {
+		return fromProbeError(err)
+	}
+	return nil
+}
+
+func fromProbeError(err error) error {
+	if perr, ok := err.(*probe.Error); ok {
+		return &uploadError{Code: perr.Code, Message: perr.Message}
+	}
+	return err
+}
+
+// checkTags - checks number of image tags and presence of tags required by ts
+func checkTags(ts *storage.TagSettings, tags []string) error {
+	if ts.MaxTags > 0 && len(tags) > ts.MaxTags {
+		return rejectUpload(ErrCodeTooManyTags, "image with tag %q can have at most %v tags, got %v", ts.Tag, ts.MaxTags, len(tags))
+	}
+	for _, required := range ts.RequiredTags {
+		if !contains(tags, required) {
+			return rejectUpload(ErrCodeMissingTag, "image with tag %q should also have tag %q", ts.Tag, required)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -4,39 +4,88 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/imagemeta"
+	"github.com/KazanExpress/louis/internal/pkg/placeholder"
+	"github.com/KazanExpress/louis/internal/pkg/probe"
 	"github.com/KazanExpress/louis/internal/pkg/storage"
 	"github.com/KazanExpress/louis/internal/pkg/transformations"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"log"
+	"net/url"
+	"path"
 	"strings"
 	"sync"
+	"time"
 )
 
+// ReplaceReservationTTL - time after which version reserved by replacement, e.g. crashed one, can be reserved again
+const ReplaceReservationTTL = 10 * time.Minute
+
 var (
 	ImageCanNotBeRestoredError = fmt.Errorf("image can not be restored")
 	ImageNotArchivedError      = fmt.Errorf("image is not archived, nothing to restore")
+	ImageCanNotBeDerivedError  = fmt.Errorf("image is archived, it should be restored before deriving")
+	ImageCanNotBeReplacedError = fmt.Errorf("image is archived, it should be restored before replacing")
 
 	realTransformation     = storage.Transformation{Type: "real", Name: RealTransformName}
 	originalTransformation = storage.Transformation{Type: "original", Name: OriginalTransformName, Quality: OriginalTransformQuality}
+	posterTransformation   = storage.Transformation{Type: "poster", Name: PosterTransformName, Quality: OriginalTransformQuality}
 )
 
 // ImageService - interface of a service for uploading and transforming image
 type ImageService interface {
 	// Get()
-	Upload(*UploadArgs) (map[string]string, error)
+	Upload(*UploadArgs) (*UploadResults, error)
 	// Approve()
 	Archive(imageKey string) error
 	Restore(key string) error
+	// Derive - returns result of applying operations to best stored copy of image
+	Derive(image *storage.Image, ops []storage.Operation) (ImageBuffer, error)
+	// Replace - uploads new version of image, objects of previous version are deleted after retention period
+	Replace(image *storage.Image, params transformations.TransformParams) (*UploadResults, error)
+	DeleteVersion(imageKey string, version int) error
+	// Copy - fills image record with given key by copies of objects and stored fields of source image
+	Copy(source *storage.Image, imageKey string) error
+	// PresignStaged - returns URL which client uploads staged object with
+	PresignStaged(objectKey string) (string, error)
+	// FetchStaged - downloads staged object, it is rejected if it is larger than MAX_IMAGE_SIZE
+	FetchStaged(objectKey string) (ImageBuffer, error)
+	DeleteStaged(objectKey string) error
+	// StageObject - uploads private staged object, e.g. chunk of resumable upload
+	StageObject(objectKey string, data ImageBuffer) error
+	// ListStaged - returns keys of staged objects with prefix
+	ListStaged(prefix string) ([]string, error)
+	DeleteStagedFolder(prefix string) error
+	// PresignObject - returns URL of private object of storage profile valid for PRIVATE_URL_EXPIRATION
+	PresignObject(profile, objectKey string) (string, error)
+	// Replicate - copies "real" object of current image version to replica store
+	Replicate(imageKey string) error
 }
 
 type UploadArgs struct {
 	ImageKey string
 	ImageID  int64
-	Params   transformations.TransformParams
+	// Version - version of image which objects are uploaded, see versionPrefix
+	Version int
+	// ContentHash - hash of uploaded file, it is calculated if empty
+	ContentHash string
+	Params      transformations.TransformParams
 }
 
 type UploadResults struct {
 	TransformURLs map[string]string
+	// Transformations - all transformations applied to image
+	Transformations []storage.Transformation
+	Placeholder     *placeholder.Placeholder
+	Version         int
+	// Private - if objects are uploaded without public ACL, see storage.TagSettings
+	Private bool
+	// Folder - folder of uploaded objects, see ObjectFolder
+	Folder string
+	// Storage - storage profile of uploaded objects, see storage.TagSettings
+	Storage string
+	// Animated - if animation of image is kept by transformations, see transformFormat
+	Animated bool
 }
 
 // LouisService - implementation of ImageService
@@ -54,7 +103,33 @@ type ImageBuffer = []byte
 
 type imageTransformer = func(args transformations.TransformParams, trans *storage.Transformation) (ImageBuffer, error)
 
-func (svc *LouisService) upload(transformationsList []storage.Transformation, args transformations.TransformParams, imageKey string) (map[string]string, error) {
+// uploadTarget - where and how objects of image are uploaded
+type uploadTarget struct {
+	imageKey string
+	// prefix - folder of image version, see versionPrefix
+	prefix string
+	// tags - tags of image, they choose host of public URL
+	tags    []string
+	private bool
+	// store - storage of image profile, realStorageClass is applied to "real" copy only
+	store            *storage.S3Context
+	realStorageClass string
+}
+
+// uploadKeepingAnimation - uploads transformations, if animation can not be kept, e.g. linked libvips
+// can not save gif, they are uploaded again from static first frame and params.Animated is reset
+func (svc *LouisService) uploadKeepingAnimation(transformationsList []storage.Transformation, params *transformations.TransformParams, target uploadTarget) (map[string]string, error) {
+	var transformURLs, err = svc.upload(transformationsList, *params, target)
+	if err != nil && params.Animated {
+		log.Printf("WARN: failed to keep animation of image %v, first frame is used - %v", target.imageKey, err)
+		params.Animated = false
+		transformURLs, err = svc.upload(transformationsList, *params, target)
+	}
+	return transformURLs, err
+}
+
+// upload - applies transformations and uploads results into folder given by target prefix
+func (svc *LouisService) upload(transformationsList []storage.Transformation, args transformations.TransformParams, target uploadTarget) (map[string]string, error) {
 
 	var wg sync.WaitGroup
 	var allTransformationsCount = len(transformationsList)
@@ -64,19 +139,45 @@ func (svc *LouisService) upload(transformationsList []storage.Transformation, ar
 	var ctx, cancelCtx = context.WithCancel(context.Background())
 	defer cancelCtx()
 
+	// "real" transform keeps uploaded image untouched, others get image converted to sRGB
+	var normalizedArgs = args
+	normalizedArgs.Image = svc.normalizeColour(target.imageKey, args.Image)
+
 	wg.Add(allTransformationsCount)
 
 	var makeTransformation = func(localCtx context.Context, transformName string, transformer imageTransformer, trans storage.Transformation) {
 		defer wg.Done()
-		var transformedImage, err = transformer(args, &trans)
+		var params = normalizedArgs
+		if trans.Type == realTransformation.Type {
+			params = args
+		}
+		var transformedImage, err = transformer(params, &trans)
+		if err == nil {
+			transformedImage, err = transformations.PreserveMetadata(params.Image, transformedImage, &trans)
+		}
 		if err != nil {
 			errors <- err
 			return
 		}
-		url, err := svc.ctx.Storage.UploadFileWithContext(
+		var extension, _ = transformFormat(&trans, args.Animated)
+		var opts = svc.objectHeaders(&trans, target.imageKey, target.tags, extension)
+		opts.Private = target.private
+		opts.Encryption = svc.ctx.Encryption.Current(trans.Type == realTransformation.Type)
+		if trans.Type == realTransformation.Type {
+			opts.StorageClass = target.realStorageClass
+			// animated source is stored as is under "real.jpg" name, so content type is taken from its format
+			if info, perr := probe.Probe(transformedImage); perr == nil {
+				opts.ContentType = contentType(info.Format)
+			}
+		}
+		url, err := target.store.UploadFileWithContext(
 			localCtx,
 			bytes.NewReader(transformedImage),
-			makePath(transformName, imageKey))
+			makePath(transformName, target.prefix, extension),
+			opts)
+		if svc.ctx.PublicURL.Enabled() {
+			url = svc.ctx.PublicURL.URL(target.tags, target.prefix, transformName, extension)
+		}
 		transformURLs.Set(transformName, url)
 		if err != nil {
 			errors <- err
@@ -120,7 +221,7 @@ func (svc *LouisService) upload(transformationsList []storage.Transformation, ar
 }
 
 // Upload - upload original image and it's transformations
-func (svc *LouisService) Upload(args *UploadArgs) (map[string]string, error) {
+func (svc *LouisService) Upload(args *UploadArgs) (*UploadResults, error) {
 
 	var newTransformationsList, err = svc.ctx.DB.GetTransformations(args.ImageID)
 	if err != nil {
@@ -132,20 +233,670 @@ func (svc *LouisService) Upload(args *UploadArgs) (map[string]string, error) {
 		originalTransformation,
 	)
 
-	transformUrls, err := svc.upload(newTransformationsList, args.Params, args.ImageKey)
+	if args.ContentHash == "" {
+		args.ContentHash = contentHash(args.Params.Image, args.Params.CropSquare)
+	}
+
+	var frames = 1
+	if info, perr := probe.Probe(args.Params.Image); perr == nil {
+		frames = info.Frames
+	}
+	if frames > 1 {
+		newTransformationsList = append(newTransformationsList, posterTransformation)
+		args.Params.Source = args.Params.Image
+		args.Params.Animated = svc.animationAllowed(args.ImageKey)
+	}
+
+	// formats like heif are converted once, so "real" copy can be transformed on restore
+	args.Params.Image, err = transformations.ToTransformable(args.Params.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := svc.ctx.DB.QueryImageByKey(args.ImageKey)
 	if err != nil {
 		return nil, err
 	}
+	private, err := svc.privateImage(image.Tags)
+	if err != nil {
+		return nil, err
+	}
+	// folder and storage are chosen on first upload, replaced versions are stored in the same place
+	var folder = imageFolder(image)
+	var profile, realStorageClass = image.Storage, image.RealStorageClass
+	if args.Version == 0 && image.Folder == "" {
+		folder = svc.ctx.KeyLayout.Folder(image.Key, image.Tags, image.UserID, image.CreateDate)
+		if profile, realStorageClass, err = svc.imageStorage(image.Tags); err != nil {
+			return nil, err
+		}
+		if err = svc.ctx.DB.SetImageFolder(image.Key, folder); err != nil {
+			return nil, err
+		}
+		if err = svc.ctx.DB.SetImageStorage(image.Key, profile, realStorageClass); err != nil {
+			return nil, err
+		}
+	}
+	store, err := svc.ctx.Storage.Profile(profile)
+	if err != nil {
+		return nil, err
+	}
+	// storage is chosen by other tags of image, so it may be unable to keep objects private
+	if private && !store.SupportsACL() {
+		return nil, fmt.Errorf("private image %v can not be stored in storage %q without ACL support", args.ImageKey, profile)
+	}
+	var prefix = versionPrefix(folder, args.Version)
+	transformUrls, err := svc.uploadKeepingAnimation(newTransformationsList, &args.Params, uploadTarget{
+		imageKey:         args.ImageKey,
+		prefix:           prefix,
+		tags:             image.Tags,
+		private:          private,
+		store:            store,
+		realStorageClass: realStorageClass,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = svc.ctx.DB.SetImagePrivate(args.ImageKey, private); err != nil {
+		return nil, err
+	}
+	if err = svc.ctx.DB.SetImageRealEncryptionKey(args.ImageKey, svc.ctx.Encryption.CurrentKeyID()); err != nil {
+		return nil, err
+	}
 
 	err = svc.ctx.DB.SetTransformsUploaded(args.ImageID)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.updateMetadata(args.ImageKey, args.Params.Image)
+	svc.updatePerceptualHash(args.ImageKey, args.Params.Image)
+	if err = svc.ctx.DB.SetImageFrames(args.ImageKey, frames, args.Params.Animated); err != nil {
+		return nil, err
+	}
+	if err = svc.ctx.DB.SetImageContentHash(args.ImageKey, args.ContentHash); err != nil {
+		return nil, err
+	}
+	svc.enqueueReplication(args.ImageKey)
 
-	return transformUrls, err
+	return &UploadResults{
+		TransformURLs:   transformUrls,
+		Transformations: newTransformationsList,
+		Placeholder:     svc.updatePlaceholder(args.ImageKey, args.Params.Image),
+		Version:         args.Version,
+		Private:         private,
+		Folder:          prefix,
+		Storage:         profile,
+		Animated:        args.Params.Animated,
+	}, nil
 }
 
-// Archive - delete all transforms except real
+func (svc *LouisService) Replace(image *storage.Image, params transformations.TransformParams) (*UploadResults, error) {
+	if image.Deleted {
+		return nil, ImageCanNotBeReplacedError
+	}
+	// next version is reserved before upload, so concurrent replacements do not write objects of the same version,
+	// image is served from current version until objects of next one are uploaded
+	if err := svc.ctx.DB.ReserveImageVersion(image.Key, image.Version, time.Now().Add(-ReplaceReservationTTL)); err != nil {
+		return nil, err
+	}
+	var results, err = svc.Upload(&UploadArgs{
+		ImageKey: image.Key,
+		ImageID:  image.ID,
+		Version:  image.Version + 1,
+		Params:   params,
+	})
+	if err != nil {
+		if rerr := svc.ctx.DB.ReleaseImageVersion(image.Key, image.Version); rerr != nil {
+			log.Printf("ERROR: failed to release version %v of image %v: %v", image.Version+1, image.Key, rerr)
+		}
+		return nil, err
+	}
+	err = svc.ctx.DB.CommitImageVersion(image.Key, image.Version, results.TransformURLs[OriginalTransformName])
+	if err != nil {
+		return nil, err
+	}
+
+	var delay = int64(svc.ctx.Config.VersionRetention.Seconds())
+	_, err = svc.ctx.Enqueuer.EnqueueIn(DeleteVersionTask, delay, map[string]interface{}{"key": image.Key, "version": image.Version})
+	if err != nil {
+		log.Printf("ERROR: failed to enqueue deletion of version %v of image %v: %v", image.Version, image.Key, err)
+	}
+	return results, nil
+}
+
+// DeleteVersion - deletes all objects of given image version
+func (svc *LouisService) DeleteVersion(imageKey string, version int) error {
+	var image, err = svc.ctx.DB.QueryImageByKey(imageKey)
+	if err != nil {
+		return err
+	}
+	store, err := svc.ctx.Storage.Profile(image.Storage)
+	if err != nil {
+		return err
+	}
+	var prefix = versionPrefix(imageFolder(image), version)
+	if svc.ctx.Replica != nil {
+		if err = svc.ctx.Replica.DeleteObject(makePath(RealTransformName, prefix, ImageExtension)); err != nil {
+			log.Printf("WARN: failed to delete replica of version %v of image %v - %v", version, imageKey, err)
+		}
+	}
+	files, err := svc.versionFiles(store, prefix)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	return store.DeleteFiles(files)
+}
+
+// Copy - objects are copied within storage of source, so copy keeps its storage profile and classes
+func (svc *LouisService) Copy(source *storage.Image, imageKey string) error {
+	var image, err = svc.ctx.DB.QueryImageByKey(imageKey)
+	if err != nil {
+		return err
+	}
+	store, err := svc.ctx.Storage.Profile(source.Storage)
+	if err != nil {
+		return err
+	}
+	byName, err := svc.transformationsByName(image.ID)
+	if err != nil {
+		return err
+	}
+	var folder = svc.ctx.KeyLayout.Folder(image.Key, image.Tags, image.UserID, image.CreateDate)
+	var prefix = ObjectFolder(source)
+	files, err := svc.versionFiles(store, prefix)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		// headers are replaced, so metadata of copy has its own key
+		var trans, extension = objectTransformation(byName, *file.Key)
+		var real = trans.Type == realTransformation.Type
+		var opts = svc.objectHeaders(&trans, imageKey, image.Tags, extension)
+		opts.Private = source.Private
+		opts.Encryption = svc.ctx.Encryption.Current(real)
+		if real {
+			opts.StorageClass = source.RealStorageClass
+			if opts.SourceEncryption, err = svc.ctx.Encryption.Read(source.RealEncryptionKey); err != nil {
+				return err
+			}
+			// "real" copy of animated image is not jpeg, its content type is kept
+			if opts.ContentType, err = store.ObjectContentType(*file.Key, opts.SourceEncryption); err != nil {
+				return err
+			}
+		}
+		if err = store.CopyObject(*file.Key, folder+strings.TrimPrefix(*file.Key, prefix), opts); err != nil {
+			return err
+		}
+	}
+	var url = strings.Replace(source.URL, prefix+"/", folder+"/", 1)
+	if svc.ctx.PublicURL.Enabled() {
+		url = svc.ctx.PublicURL.URL(source.Tags, folder, OriginalTransformName, ImageExtension)
+	}
+	err = svc.ctx.DB.Update(imageKey, map[string]interface{}{
+		"Url":                    url,
+		"Folder":                 folder,
+		"Transforms_Uploaded":    true,
+		"Transforms_Upload_Date": time.Now(),
+		"With_Real_Copy":         source.WithRealCopy,
+		"Progressive":            source.Progressive,
+		"Blur_Hash":              source.BlurHash,
+		"Dominant_Color":         source.DominantColor,
+		"Preview":                source.Preview,
+		"Metadata":               source.Metadata,
+		"Frames":                 source.Frames,
+		"Animated":               source.Animated,
+		"Content_Hash":           source.ContentHash,
+		"Perceptual_Hash":        source.PerceptualHash,
+		"Perceptual_Hash_Band0":  source.PerceptualHashBand0,
+		"Perceptual_Hash_Band1":  source.PerceptualHashBand1,
+		"Perceptual_Hash_Band2":  source.PerceptualHashBand2,
+		"Perceptual_Hash_Band3":  source.PerceptualHashBand3,
+		"Private":                source.Private,
+		"Storage":                source.Storage,
+		"Real_Storage_Class":     source.RealStorageClass,
+		"Real_Encryption_Key":    svc.ctx.Encryption.CurrentKeyID(),
+	})
+	if err != nil {
+		return err
+	}
+	svc.enqueueReplication(imageKey)
+	return nil
+}
+
+func (svc *LouisService) PresignStaged(objectKey string) (string, error) {
+	return svc.ctx.Storage.PresignPutObject(objectKey, svc.ctx.Config.DirectUploadExpiration)
+}
+
+func (svc *LouisService) FetchStaged(objectKey string) (ImageBuffer, error) {
+	var size, err = svc.ctx.Storage.ObjectSize(objectKey)
+	if err != nil {
+		return nil, err
+	}
+	if size > svc.ctx.Config.MaxImageSize {
+		return nil, rejectUpload(ErrCodeFileTooLarge, "image size should be less than %v bytes", svc.ctx.Config.MaxImageSize)
+	}
+	return svc.ctx.Storage.GetObject(objectKey)
+}
+
+func (svc *LouisService) DeleteStaged(objectKey string) error {
+	return svc.ctx.Storage.DeleteObject(objectKey)
+}
+
+func (svc *LouisService) StageObject(objectKey string, data ImageBuffer) error {
+	var _, err = svc.ctx.Storage.UploadFileWithContext(context.Background(), bytes.NewReader(data), objectKey, storage.ObjectOptions{
+		ContentType: "application/octet-stream",
+		Private:     true,
+	})
+	return err
+}
+
+func (svc *LouisService) ListStaged(prefix string) ([]string, error) {
+	var files, err = svc.ctx.Storage.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys = make([]string, len(files))
+	for i, file := range files {
+		keys[i] = *file.Key
+	}
+	return keys, nil
+}
+
+func (svc *LouisService) DeleteStagedFolder(prefix string) error {
+	return svc.ctx.Storage.DeleteFolder(prefix)
+}
+
+func (svc *LouisService) PresignObject(profile, objectKey string) (string, error) {
+	var store, err = svc.ctx.Storage.Profile(profile)
+	if err != nil {
+		return "", err
+	}
+	return store.PresignGetObject(objectKey, svc.ctx.Config.PrivateURLExpiration)
+}
+
+func (svc *LouisService) Replicate(imageKey string) error {
+	if svc.ctx.Replica == nil {
+		return fmt.Errorf("replica is not configured")
+	}
+	var image, err = svc.ctx.DB.QueryImageByKey(imageKey)
+	if err != nil {
+		return err
+	}
+	if !image.WithRealCopy {
+		return nil
+	}
+	// replica is encrypted like new "real" copies, so originals are never stored decrypted
+	var encryption = svc.ctx.Encryption.Current(true)
+	data, err := svc.getObject(image, RealTransformName)
+	if err == nil {
+		err = svc.ctx.Replica.PutEncryptedObject(makePath(RealTransformName, ObjectFolder(image), ImageExtension), data, encryption)
+	}
+	if err != nil {
+		if serr := svc.ctx.DB.SetImageReplicaStatus(imageKey, storage.ReplicaFailed); serr != nil {
+			log.Printf("WARN: failed to mark replication of image %v as failed - %v", imageKey, serr)
+		}
+		return err
+	}
+	return svc.ctx.DB.SetImageReplicated(imageKey, encryption.KeyID())
+}
+
+// enqueueReplication - schedules replication of "real" copy if replica is configured,
+// image stays pending if enqueue fails, so it is picked up by replica-sync
+func (svc *LouisService) enqueueReplication(imageKey string) {
+	if svc.ctx.Replica == nil {
+		return
+	}
+	if err := svc.ctx.DB.SetImageReplicaStatus(imageKey, storage.ReplicaPending); err != nil {
+		log.Printf("WARN: failed to mark replication of image %v as pending - %v", imageKey, err)
+		return
+	}
+	if _, err := svc.ctx.Enqueuer.EnqueueUnique(ReplicateTask, map[string]interface{}{"key": imageKey}); err != nil {
+		log.Printf("ERROR: failed to enqueue replication of image %v - %v", imageKey, err)
+	}
+}
+
+// versionFiles - lists objects of image version, other versions are stored in nested folders and skipped
+func (svc *LouisService) versionFiles(store *storage.S3Context, prefix string) ([]storage.ObjectID, error) {
+	var files, err = store.ListFiles(prefix + "/")
+	if err != nil {
+		return nil, err
+	}
+	var result = make([]storage.ObjectID, 0, len(files))
+	for _, file := range files {
+		if path.Dir(*file.Key) == prefix {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
+
+// updatePlaceholder - calculates and stores placeholder of image,
+// image can be used without placeholder, so errors are only logged
+func (svc *LouisService) updatePlaceholder(imageKey string, image ImageBuffer) *placeholder.Placeholder {
+	var p, err = transformations.MakePlaceholder(image)
+	if err != nil {
+		log.Printf("WARN: failed to make placeholder for image %v - %v", imageKey, err)
+		return nil
+	}
+	if err = svc.ctx.DB.SetImagePlaceholder(imageKey, p); err != nil {
+		log.Printf("WARN: failed to save placeholder for image %v - %v", imageKey, err)
+	}
+	return p
+}
+
+// animationAllowed - reports whether settings of any image tag allow to keep animation
+func (svc *LouisService) animationAllowed(imageKey string) bool {
+	var image, err = svc.ctx.DB.QueryImageByKey(imageKey)
+	if err != nil {
+		log.Printf("WARN: failed to query image %v - %v", imageKey, err)
+		return false
+	}
+	settings, err := svc.ctx.DB.GetTagSettings(image.Tags)
+	if err != nil {
+		log.Printf("WARN: failed to get tag settings of image %v - %v", imageKey, err)
+		return false
+	}
+	for _, ts := range settings {
+		if ts.AllowAnimation {
+			return true
+		}
+	}
+	return false
+}
+
+// privateImage - checks if any of image tags makes it private, unlike animationAllowed
+// errors are returned, so image is never made public by mistake
+func (svc *LouisService) privateImage(tags []string) (bool, error) {
+	var settings, err = svc.ctx.DB.GetTagSettings(tags)
+	if err != nil {
+		return false, err
+	}
+	for _, ts := range settings {
+		if ts.Private {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// imageStorage - returns storage profile and storage class of "real" copy chosen by settings of image tags,
+// the first tag which sets them wins, storage class falls back to REAL_STORAGE_CLASS
+func (svc *LouisService) imageStorage(tags []string) (string, string, error) {
+	var settings, err = svc.ctx.DB.GetTagSettings(tags)
+	if err != nil {
+		return "", "", err
+	}
+	var byTag = make(map[string]storage.TagSettings, len(settings))
+	for _, ts := range settings {
+		byTag[ts.Tag] = ts
+	}
+	var profile, realStorageClass string
+	for _, tag := range tags {
+		var ts = byTag[tag]
+		if profile == "" {
+			profile = ts.Storage
+		}
+		if realStorageClass == "" {
+			realStorageClass = ts.RealStorageClass
+		}
+	}
+	if realStorageClass == "" {
+		realStorageClass = svc.ctx.Config.RealStorageClass
+	}
+	return profile, realStorageClass, nil
+}
+
+// updatePerceptualHash - calculates and stores perceptual hash of image used to find similar images
+func (svc *LouisService) updatePerceptualHash(imageKey string, image ImageBuffer) {
+	var hash, err = transformations.PerceptualHash(image)
+	if err != nil {
+		log.Printf("WARN: failed to calculate perceptual hash of image %v - %v", imageKey, err)
+		return
+	}
+	if err = svc.ctx.DB.SetImagePerceptualHash(imageKey, hash); err != nil {
+		log.Printf("WARN: failed to save perceptual hash of image %v - %v", imageKey, err)
+	}
+}
+
+// normalizeColour - converts image to sRGB if it's enabled,
+// image is used as is if conversion fails
+func (svc *LouisService) normalizeColour(imageKey string, image ImageBuffer) ImageBuffer {
+	if svc.ctx.Config.SRGBProfile == "" {
+		return image
+	}
+	var normalized, err = transformations.NormalizeColour(image, svc.ctx.Config.SRGBProfile)
+	if err != nil {
+		log.Printf("WARN: failed to convert image %v to sRGB - %v", imageKey, err)
+		return image
+	}
+	return normalized
+}
+
+// updateMetadata - extracts EXIF, IPTC and color space of uploaded image and stores them,
+// GPS location is dropped unless it is allowed by config
+func (svc *LouisService) updateMetadata(imageKey string, image ImageBuffer) {
+	var m, err = imagemeta.Extract(image)
+	if err != nil {
+		log.Printf("WARN: failed to read metadata of image %v - %v", imageKey, err)
+	}
+	if m == nil {
+		m = &imagemeta.Metadata{}
+	}
+	if !svc.ctx.Config.KeepGPSMetadata {
+		m.GPS = nil
+	}
+	m.ColorSpace, m.ICCProfile, err = transformations.ColourSpace(image)
+	if err != nil {
+		log.Printf("WARN: failed to read color space of image %v - %v", imageKey, err)
+	}
+	if err = svc.ctx.DB.SetImageMetadata(imageKey, m); err != nil {
+		log.Printf("WARN: failed to save metadata of image %v - %v", imageKey, err)
+	}
+}
+
+// UpdatePlaceholder - calculates placeholder of already uploaded image from its best stored copy
+func (svc *LouisService) UpdatePlaceholder(image *storage.Image) error {
+	var baseImage, err = svc.getObject(image, baseTransformName(image))
+	if err != nil {
+		return err
+	}
+	baseImage, err = transformations.ToTransformable(baseImage)
+	if err != nil {
+		return err
+	}
+	p, err := transformations.MakePlaceholder(baseImage)
+	if err != nil {
+		return err
+	}
+	return svc.ctx.DB.SetImagePlaceholder(image.Key, p)
+}
+
+// RewriteURL - stores URL of image built by public URL template, it returns false if URL is not changed
+func (svc *LouisService) RewriteURL(image *storage.Image) (bool, error) {
+	if !svc.ctx.PublicURL.Enabled() {
+		return false, fmt.Errorf("public url template is not set")
+	}
+	var url = svc.ctx.PublicURL.URL(image.Tags, ObjectFolder(image), OriginalTransformName, ImageExtension)
+	if url == image.URL {
+		return false, nil
+	}
+	return true, svc.ctx.DB.Update(image.Key, map[string]interface{}{"Url": url})
+}
+
+// getObject - downloads object of transformation of current image version from storage of image
+func (svc *LouisService) getObject(image *storage.Image, transformName string) (ImageBuffer, error) {
+	var store, err = svc.ctx.Storage.Profile(image.Storage)
+	if err != nil {
+		return nil, err
+	}
+	var encryption *storage.Encryption
+	if transformName == RealTransformName {
+		if encryption, err = svc.ctx.Encryption.Read(image.RealEncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+	return store.GetEncryptedObject(makePath(transformName, ObjectFolder(image), ImageExtension), encryption)
+}
+
+// UpdateHeaders - copies objects of current image version in place with headers of their transformations,
+// "real" copy is re-encrypted with current key, it returns number of updated objects
+func (svc *LouisService) UpdateHeaders(image *storage.Image) (int, error) {
+	var store, err = svc.ctx.Storage.Profile(image.Storage)
+	if err != nil {
+		return 0, err
+	}
+	byName, err := svc.transformationsByName(image.ID)
+	if err != nil {
+		return 0, err
+	}
+	sourceEncryption, err := svc.ctx.Encryption.Read(image.RealEncryptionKey)
+	if err != nil {
+		return 0, err
+	}
+	files, err := svc.versionFiles(store, ObjectFolder(image))
+	if err != nil {
+		return 0, err
+	}
+	for i, file := range files {
+		var trans, extension = objectTransformation(byName, *file.Key)
+		var real = trans.Type == realTransformation.Type
+		var opts = svc.objectHeaders(&trans, image.Key, image.Tags, extension)
+		opts.Private = image.Private
+		opts.Encryption = svc.ctx.Encryption.Current(real)
+		if real {
+			opts.StorageClass = image.RealStorageClass
+			opts.SourceEncryption = sourceEncryption
+			// "real" copy of animated image is not jpeg, its content type is kept
+			if opts.ContentType, err = store.ObjectContentType(*file.Key, sourceEncryption); err != nil {
+				return i, err
+			}
+		}
+		if err = store.CopyObject(*file.Key, *file.Key, opts); err != nil {
+			return i, err
+		}
+		if real {
+			if err = svc.ctx.DB.SetImageRealEncryptionKey(image.Key, svc.ctx.Encryption.CurrentKeyID()); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(files), nil
+}
+
+// objectHeaders - returns headers of object of transformation, metadata tells which image object belongs to
+func (svc *LouisService) objectHeaders(trans *storage.Transformation, imageKey string, tags []string, extension string) storage.ObjectOptions {
+	var cacheControl = trans.CacheControl
+	if cacheControl == "" {
+		cacheControl = svc.ctx.Config.CacheControl
+	}
+	var disposition = strings.NewReplacer("{key}", imageKey, "{transform}", trans.Name, "{ext}", extension).
+		Replace(trans.ContentDisposition)
+	var metadata = map[string]string{"Image-Key": imageKey}
+	if len(tags) > 0 {
+		// metadata values should be ASCII
+		var escaped = make([]string, len(tags))
+		for i, tag := range tags {
+			escaped[i] = url.QueryEscape(tag)
+		}
+		metadata["Tags"] = strings.Join(escaped, ",")
+	}
+	return storage.ObjectOptions{
+		ContentType:        contentType(extension),
+		CacheControl:       cacheControl,
+		ContentDisposition: disposition,
+		Metadata:           metadata,
+	}
+}
+
+// transformationsByName - returns transformations of image tags together with built in ones
+func (svc *LouisService) transformationsByName(imageID int64) (map[string]storage.Transformation, error) {
+	var list, err = svc.ctx.DB.GetTransformations(imageID)
+	if err != nil {
+		return nil, err
+	}
+	var byName = make(map[string]storage.Transformation, len(list)+3)
+	for _, trans := range append(list, realTransformation, originalTransformation, posterTransformation) {
+		byName[trans.Name] = trans
+	}
+	return byName, nil
+}
+
+// Reencrypt - copies "real" object of current image version in place with current encryption
+// and writes its replica again, it returns false if both are already encrypted with current key
+func (svc *LouisService) Reencrypt(image *storage.Image) (bool, error) {
+	var keyID = svc.ctx.Encryption.CurrentKeyID()
+	if !image.WithRealCopy {
+		return false, nil
+	}
+	var changed = false
+	if image.RealEncryptionKey != keyID {
+		var store, err = svc.ctx.Storage.Profile(image.Storage)
+		if err != nil {
+			return false, err
+		}
+		source, err := svc.ctx.Encryption.Read(image.RealEncryptionKey)
+		if err != nil {
+			return false, err
+		}
+		var objectKey = makePath(RealTransformName, ObjectFolder(image), ImageExtension)
+		err = store.CopyObject(objectKey, objectKey, storage.ObjectOptions{
+			Private:          image.Private,
+			StorageClass:     image.RealStorageClass,
+			Encryption:       svc.ctx.Encryption.Current(true),
+			SourceEncryption: source,
+		})
+		if err != nil {
+			return false, err
+		}
+		if err = svc.ctx.DB.SetImageRealEncryptionKey(image.Key, keyID); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	if svc.ctx.Replica != nil && image.ReplicaStatus == storage.ReplicaDone && image.ReplicaEncryptionKey != keyID {
+		if err := svc.Replicate(image.Key); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// baseTransformName - returns name of transform which has the best quality copy of image
+func baseTransformName(image *storage.Image) string {
+	if image.WithRealCopy {
+		return RealTransformName
+	}
+	return OriginalTransformName
+}
+
+func (svc *LouisService) Derive(image *storage.Image, ops []storage.Operation) (ImageBuffer, error) {
+	if image.Deleted {
+		return nil, ImageCanNotBeDerivedError
+	}
+	var baseImage, err = svc.getObject(image, baseTransformName(image))
+	if err != nil {
+		return nil, err
+	}
+	baseImage, err = transformations.ToTransformable(baseImage)
+	if err != nil {
+		return nil, err
+	}
+	return transformations.Derive(baseImage, ops)
+}
+
+// Archive - delete all transforms of current image version except real
 func (svc *LouisService) Archive(imageKey string) error {
 
-	files, err := svc.ctx.Storage.ListFiles(imageKey)
+	image, err := svc.ctx.DB.QueryImageByKey(imageKey)
+	if err != nil {
+		return err
+	}
+	store, err := svc.ctx.Storage.Profile(image.Storage)
+	if err != nil {
+		return err
+	}
+	files, err := svc.versionFiles(store, ObjectFolder(image))
 	if err != nil {
 		return err
 	}
@@ -153,11 +904,13 @@ func (svc *LouisService) Archive(imageKey string) error {
 	var originalKey storage.ObjectID
 	var realExists = false
 	for _, file := range files {
-		if strings.HasSuffix(*file.Key, RealTransformName+"."+ImageExtension) {
+		// names are compared exactly, so transforms like "thumb_original" are not kept
+		var name = path.Base(*file.Key)
+		if name == RealTransformName+"."+ImageExtension {
 			realExists = true
 			continue
 		}
-		if strings.HasSuffix(*file.Key, OriginalTransformName+"."+ImageExtension) {
+		if name == OriginalTransformName+"."+ImageExtension {
 			originalKey = file
 			continue
 		}
@@ -168,7 +921,7 @@ func (svc *LouisService) Archive(imageKey string) error {
 	}
 
 	if len(objectsToDelete) > 0 {
-		err = svc.ctx.Storage.DeleteFiles(objectsToDelete)
+		err = store.DeleteFiles(objectsToDelete)
 		if err != nil {
 			return err
 		}
@@ -196,7 +949,21 @@ func (svc *LouisService) Restore(imageKey string) error {
 		additionalTransformation = originalTransformation
 	}
 
-	baseImage, err := svc.ctx.Storage.GetObject(makePath(imageTransformToUse, image.Key))
+	store, err := svc.ctx.Storage.Profile(image.Storage)
+	if err != nil {
+		return err
+	}
+	baseImage, err := svc.getObject(image, imageTransformToUse)
+
+	// "real" copy missing in primary storage is taken from replica and uploaded back
+	var fromReplica = false
+	if err == storage.NoSuchKeyError && image.WithRealCopy && image.ReplicaStatus == storage.ReplicaDone && svc.ctx.Replica != nil {
+		var encryption *storage.Encryption
+		if encryption, err = svc.ctx.Encryption.Read(image.ReplicaEncryptionKey); err == nil {
+			baseImage, err = svc.ctx.Replica.GetEncryptedObject(makePath(RealTransformName, ObjectFolder(image), ImageExtension), encryption)
+		}
+		fromReplica = err == nil
+	}
 
 	if err != nil {
 		if err == storage.NoSuchKeyError {
@@ -212,19 +979,58 @@ func (svc *LouisService) Restore(imageKey string) error {
 	}
 
 	transformationsList = append(transformationsList, additionalTransformation)
+	if image.Frames > 1 {
+		transformationsList = append(transformationsList, posterTransformation)
+	}
+	if fromReplica {
+		log.Printf("INFO: real copy of image %v is missing, restoring it from replica", imageKey)
+		transformationsList = append(transformationsList, realTransformation)
+	}
+
+	// "real" copy of animated image is uploaded file, so animation is restored like on upload
+	var params = transformations.TransformParams{Image: baseImage}
+	if info, perr := probe.Probe(baseImage); perr == nil && info.Frames > 1 {
+		params.Source = baseImage
+		params.Animated = svc.animationAllowed(imageKey)
+		if params.Image, err = transformations.ToTransformable(baseImage); err != nil {
+			return err
+		}
+	}
 
-	_, err = svc.upload(transformationsList, transformations.TransformParams{Image: baseImage}, imageKey)
+	_, err = svc.uploadKeepingAnimation(transformationsList, &params, uploadTarget{
+		imageKey:         imageKey,
+		prefix:           ObjectFolder(image),
+		tags:             image.Tags,
+		private:          image.Private,
+		store:            store,
+		realStorageClass: image.RealStorageClass,
+	})
 
 	if err != nil {
 		return err
 	}
 
+	if fromReplica || !image.WithRealCopy {
+		if err = svc.ctx.DB.SetImageRealEncryptionKey(imageKey, svc.ctx.Encryption.CurrentKeyID()); err != nil {
+			return err
+		}
+	}
+
 	err = svc.ctx.DB.SetTransformsUploaded(image.ID)
 
 	if err != nil {
 		return err
 	}
 
+	svc.updatePlaceholder(imageKey, params.Image)
+
+	// images uploaded before "real" copy kept animation are restored from first frame
+	if image.Animated != params.Animated {
+		if err = svc.ctx.DB.SetImageFrames(imageKey, image.Frames, params.Animated); err != nil {
+			return err
+		}
+	}
+
 	return svc.ctx.DB.SetImageRestored(imageKey)
 
 }
@@ -0,0 +1,123 @@
+package louis
+
+import (
+	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/phash"
+	"github.com/KazanExpress/louis/internal/pkg/transformations"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// distance is number of different bits of 64 bit perceptual hashes,
+	// default one is the largest distance searched by indexed bands
+	DefaultSimilarDistance = phash.Bands - 1
+	MaxSimilarDistance     = 16
+	DefaultSimilarLimit    = 20
+	MaxSimilarLimit        = 100
+)
+
+type similarImagePayload struct {
+	ImageKey    string    `json:"key"`
+	Distance    int       `json:"distance"`
+	OriginalURL string    `json:"originalUrl"`
+	Tags        []string  `json:"tags"`
+	CreateDate  time.Time `json:"createDate"`
+}
+
+type similarPayload struct {
+	// Hash - hex encoded perceptual hash which images are compared with
+	Hash   string                `json:"hash"`
+	Images []similarImagePayload `json:"images"`
+}
+
+// similarQuery - parses maximum distance and limit of similar images from query string
+func similarQuery(r *http.Request) (distance, limit int, err error) {
+	distance, limit = DefaultSimilarDistance, DefaultSimilarLimit
+	if value := r.URL.Query().Get("distance"); value != "" {
+		distance, err = strconv.Atoi(value)
+		if err != nil || distance < 0 || distance > MaxSimilarDistance {
+			return 0, 0, fmt.Errorf("distance should be in range 0..%v", MaxSimilarDistance)
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > MaxSimilarLimit {
+			return 0, 0, fmt.Errorf("limit should be in range 1..%v", MaxSimilarLimit)
+		}
+	}
+	return distance, limit, nil
+}
+
+// respondWithSimilar - finds images similar to hash, image with excludeKey is skipped
+func respondWithSimilar(s *session, w http.ResponseWriter, r *http.Request, hash uint64, excludeKey string) {
+	var distance, limit, err = similarQuery(r)
+	if err != nil {
+		respondWithJSON(w, err.Error(), nil, http.StatusBadRequest)
+		return
+	}
+
+	// excluded image is the closest one, so it can be only in first limit + 1 results
+	images, err := s.ctx.DB.FindSimilarImages(hash, distance, limit+1)
+	if failOnError(w, err, "failed to find similar images", http.StatusInternalServerError) {
+		return
+	}
+
+	var payload = similarPayload{Hash: fmt.Sprintf("%016x", hash), Images: make([]similarImagePayload, 0, len(images))}
+	for _, img := range images {
+		if img.Key == excludeKey || len(payload.Images) == limit {
+			continue
+		}
+		var url = img.URL
+		if img.Private {
+			if url, err = s.ctx.signURL(img.Storage, ObjectFolder(&img.Image), OriginalTransformName, ImageExtension); failOnError(w, err, "failed to sign urls", http.StatusInternalServerError) {
+				return
+			}
+		}
+		payload.Images = append(payload.Images, similarImagePayload{
+			ImageKey:    img.Key,
+			Distance:    img.Distance,
+			OriginalURL: url,
+			Tags:        img.Tags,
+			CreateDate:  img.CreateDate,
+		})
+	}
+	respondWithJSON(w, "", payload, http.StatusOK)
+}
+
+// handleSimilar - finds images similar to already uploaded one
+func handleSimilar(s *session, w http.ResponseWriter, r *http.Request) {
+	var image, err = s.ctx.DB.QueryImageByKey(mux.Vars(r)["imageKey"])
+	if err == gorm.ErrRecordNotFound {
+		respondWithJSON(w, "image not found", nil, http.StatusNotFound)
+		return
+	}
+	if failOnError(w, err, "failed to get image", http.StatusInternalServerError) {
+		return
+	}
+	if image.PerceptualHash == 0 {
+		respondWithJSON(w, "perceptual hash of image is not calculated", nil, http.StatusPreconditionFailed)
+		return
+	}
+	respondWithSimilar(s, w, r, uint64(image.PerceptualHash), image.Key)
+}
+
+// handleSearchSimilar - finds images similar to uploaded file, file is not stored
+func handleSearchSimilar(s *session, w http.ResponseWriter, r *http.Request) {
+	var image, err = transformations.ToTransformable(s.args.image)
+	if failOnError(w, err, "failed to convert image", http.StatusBadRequest) {
+		return
+	}
+	hash, err := transformations.PerceptualHash(image)
+	if failOnError(w, err, "failed to calculate perceptual hash", http.StatusBadRequest) {
+		return
+	}
+	if hash == 0 {
+		respondWithJSON(w, "image has no details to compare", nil, http.StatusBadRequest)
+		return
+	}
+	respondWithSimilar(s, w, r, hash, "")
+}
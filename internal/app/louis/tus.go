@@ -0,0 +1,382 @@
+package louis
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"github.com/gocraft/work"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ExpireUploadTask - deletes incomplete resumable upload
+	ExpireUploadTask = "expire_upload"
+
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,expiration,termination"
+
+	// ImageKeyHeader - key of image created from completed resumable upload
+	ImageKeyHeader = "Louis-Image-Key"
+	// UploadStatusHeader - status of resumable upload, see storage.UploadPending
+	UploadStatusHeader = "Louis-Upload-Status"
+
+	offsetContentType = "application/offset+octet-stream"
+)
+
+// tusProtocol - sets Tus-Resumable header and rejects requests of unsupported protocol version
+func tusProtocol(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", TusVersion)
+		if r.Method != "OPTIONS" && r.Header.Get("Tus-Resumable") != TusVersion {
+			w.Header().Set("Tus-Version", TusVersion)
+			respondWithJSON(w, "unsupported tus protocol version", nil, http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseUploadMetadata - parses tus Upload-Metadata header,
+// it is comma separated list of keys and base64 encoded values
+func parseUploadMetadata(header string) (map[string]string, error) {
+	var metadata = make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		var fields = strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+		var value []byte
+		if len(fields) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				return nil, fmt.Errorf("value of %q is not base64 encoded", fields[0])
+			}
+		}
+		metadata[fields[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+// uploadArgs - parses upload arguments passed in metadata, image is not set
+func uploadArgs(header string) (*requestArgs, error) {
+	var metadata, err = parseUploadMetadata(header)
+	if err != nil {
+		return nil, &requestError{message: "invalid Upload-Metadata", err: err, status: http.StatusBadRequest}
+	}
+	var args = &requestArgs{imageKey: metadata["key"]}
+	if args.tags, err = parseTags(metadata["tags"]); err != nil {
+		return nil, err
+	}
+	if args.cropSquare, err = parseCropPoints(metadata["cropPoints"]); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// chunksPrefix - prefix of staged chunks of upload, so every instance can continue or expire it
+func (appCtx *AppContext) chunksPrefix(id string) string {
+	return appCtx.Config.StagingPrefix + "tus/" + id + "/"
+}
+
+// chunkKey - key of staged chunk starting at offset, it is overwritten if chunk is sent again
+func (appCtx *AppContext) chunkKey(id string, offset int64) string {
+	return fmt.Sprintf("%s%020d", appCtx.chunksPrefix(id), offset)
+}
+
+// getResumableUpload - responds with 404 if upload is not found or belongs to another user
+func (s *session) getResumableUpload(w http.ResponseWriter, r *http.Request) (*storage.ResumableUpload, bool) {
+	var upload, err = s.ctx.DB.GetResumableUpload(mux.Vars(r)["uploadId"])
+	if err == gorm.ErrRecordNotFound || err == nil && upload.UserID != s.userID {
+		respondWithJSON(w, "upload not found", nil, http.StatusNotFound)
+		return nil, false
+	}
+	if failOnError(w, err, "failed to get upload", http.StatusInternalServerError) {
+		return nil, false
+	}
+	return upload, true
+}
+
+// handleTusOptions - describes supported tus protocol version and extensions
+func handleTusOptions(s *session, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", TusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.ctx.Config.MaxImageSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateUpload - creates resumable upload, tags, key and cropPoints are passed in Upload-Metadata
+func handleCreateUpload(s *session, w http.ResponseWriter, r *http.Request) {
+	var length, err = strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithJSON(w, "Upload-Length should be positive integer", nil, http.StatusBadRequest)
+		return
+	}
+	if length > s.ctx.Config.MaxImageSize {
+		respondWithErrorCode(w, ErrCodeFileTooLarge, fmt.Sprintf("image size should be less than %v bytes", s.ctx.Config.MaxImageSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var metadata = r.Header.Get("Upload-Metadata")
+	if _, err = uploadArgs(metadata); failOnArgsError(w, err) {
+		return
+	}
+
+	var upload = &storage.ResumableUpload{
+		ID:         xid.New().String(),
+		UserID:     s.userID,
+		Length:     length,
+		Metadata:   metadata,
+		Claim:      s.claim,
+		Status:     storage.UploadPending,
+		ExpireDate: time.Now().Add(s.ctx.Config.TusExpiration),
+	}
+
+	if failOnError(w, s.ctx.DB.CreateResumableUpload(upload), "failed to create upload", http.StatusInternalServerError) {
+		return
+	}
+
+	_, err = s.ctx.Enqueuer.EnqueueUniqueIn(ExpireUploadTask, int64(s.ctx.Config.TusExpiration.Seconds()), map[string]interface{}{"id": upload.ID})
+	if err != nil {
+		log.Printf("ERROR: failed to enqueue expiration of upload %v: %v", upload.ID, err)
+	}
+
+	w.Header().Set("Location", "/files/"+upload.ID)
+	w.Header().Set("Upload-Expires", upload.ExpireDate.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleUploadOffset - responds with number of received bytes of upload
+func handleUploadOffset(s *session, w http.ResponseWriter, r *http.Request) {
+	var upload, ok = s.getResumableUpload(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpireDate.UTC().Format(http.TimeFormat))
+	w.Header().Set(UploadStatusHeader, upload.Status)
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	if upload.Status == storage.UploadDone {
+		w.Header().Set(ImageKeyHeader, upload.ImageKey)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// lockResumableUpload - locks upload row until returned transaction is finished,
+// concurrent requests writing the same upload are responded with 423
+func (s *session) lockResumableUpload(w http.ResponseWriter, upload *storage.ResumableUpload) (*storage.DB, bool) {
+	var tx, locked, err = s.ctx.DB.LockResumableUpload(upload.ID)
+	if err == storage.ErrUploadLocked {
+		respondWithJSON(w, "upload is written by another request", nil, http.StatusLocked)
+		return nil, false
+	}
+	if failOnError(w, err, "failed to lock upload", http.StatusInternalServerError) {
+		return nil, false
+	}
+	*upload = *locked
+	return tx, true
+}
+
+// handleUploadChunk - stores chunk of upload, image is uploaded when all bytes are received;
+// upload is locked while chunk is written and processed, so it is completed only once
+func handleUploadChunk(s *session, w http.ResponseWriter, r *http.Request) {
+	var upload, ok = s.getResumableUpload(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != offsetContentType {
+		respondWithJSON(w, "Content-Type should be "+offsetContentType, nil, http.StatusUnsupportedMediaType)
+		return
+	}
+	tx, ok := s.lockResumableUpload(w, upload)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	if upload.Status != storage.UploadPending {
+		respondWithJSON(w, "upload is already completed", nil, http.StatusConflict)
+		return
+	}
+	if time.Now().After(upload.ExpireDate) {
+		respondWithJSON(w, "upload is expired", nil, http.StatusGone)
+		return
+	}
+	var offset, err = strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		respondWithJSON(w, "Upload-Offset does not match offset of upload", nil, http.StatusConflict)
+		return
+	}
+
+	// received bytes are kept even if connection was broken
+	chunk, copyErr := ioutil.ReadAll(io.LimitReader(r.Body, upload.Length-offset))
+	if len(chunk) > 0 {
+		err = s.ctx.ImageService.StageObject(s.ctx.chunkKey(upload.ID, offset), chunk)
+		if failOnError(w, err, "failed to store chunk", http.StatusInternalServerError) {
+			return
+		}
+		upload.Offset += int64(len(chunk))
+		if failOnError(w, tx.SetResumableUploadOffset(upload.ID, upload.Offset), "failed to save upload offset", http.StatusInternalServerError) {
+			return
+		}
+	}
+
+	if upload.Offset < upload.Length || copyErr != nil {
+		if failOnError(w, tx.Commit().Error, "failed to save upload offset", http.StatusInternalServerError) {
+			return
+		}
+		if failOnError(w, copyErr, "failed to receive chunk", http.StatusBadRequest) {
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	payload, err := s.ctx.completeUpload(tx, upload)
+	if cerr := tx.Commit().Error; cerr != nil {
+		log.Printf("ERROR: failed to save status of upload %v: %v", upload.ID, cerr)
+		if err == nil {
+			err = cerr
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if failOnArgsError(w, err) {
+		return
+	}
+	w.Header().Set(ImageKeyHeader, payload.ImageKey)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readChunks - joins staged chunks of upload, chunks are keyed by their offsets
+func (appCtx *AppContext) readChunks(upload *storage.ResumableUpload) (ImageBuffer, error) {
+	var prefix = appCtx.chunksPrefix(upload.ID)
+	var keys, err = appCtx.ImageService.ListStaged(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var chunks = make(map[int64]string, len(keys))
+	for _, key := range keys {
+		if offset, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64); err == nil {
+			chunks[offset] = key
+		}
+	}
+
+	var image = make(ImageBuffer, 0, upload.Length)
+	for int64(len(image)) < upload.Length {
+		var key, ok = chunks[int64(len(image))]
+		if !ok {
+			return nil, fmt.Errorf("chunk at offset %v is missing", len(image))
+		}
+		chunk, err := appCtx.ImageService.FetchStaged(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) == 0 {
+			return nil, fmt.Errorf("chunk at offset %v is empty", len(image))
+		}
+		image = append(image, chunk...)
+	}
+	return image[:upload.Length], nil
+}
+
+// completeUpload - passes received image to upload pipeline and saves result of processing with locked tx
+func (appCtx *AppContext) completeUpload(tx *storage.DB, upload *storage.ResumableUpload) (*uploadResponsePayload, error) {
+	var payload *uploadResponsePayload
+	var args, err = uploadArgs(upload.Metadata)
+	if err == nil {
+		args.image, err = appCtx.readChunks(upload)
+	}
+	if err == nil {
+		payload, err = appCtx.processUpload(args, upload.UserID, upload.Claim)
+	}
+	if derr := appCtx.ImageService.DeleteStagedFolder(appCtx.chunksPrefix(upload.ID)); derr != nil {
+		log.Printf("WARN: failed to delete chunks of upload %v: %v", upload.ID, derr)
+	}
+
+	if err != nil {
+		var code string
+		if uerr, ok := err.(*uploadError); ok {
+			code = uerr.Code
+		}
+		log.Printf("ERROR: failed to process resumable upload %v: %v", upload.ID, err)
+		if serr := tx.SetResumableUploadStatus(upload.ID, storage.UploadFailed, "", code, err.Error()); serr != nil {
+			log.Printf("ERROR: failed to save status of upload %v: %v", upload.ID, serr)
+		}
+		return nil, err
+	}
+
+	log.Printf("INFO: resumable upload %v completed as image with key=%v", upload.ID, payload.ImageKey)
+	return payload, tx.SetResumableUploadStatus(upload.ID, storage.UploadDone, payload.ImageKey, "", "")
+}
+
+// handleTerminateUpload - deletes upload and received chunks
+func handleTerminateUpload(s *session, w http.ResponseWriter, r *http.Request) {
+	var upload, ok = s.getResumableUpload(w, r)
+	if !ok {
+		return
+	}
+	tx, ok := s.lockResumableUpload(w, upload)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+	if err := s.ctx.ImageService.DeleteStagedFolder(s.ctx.chunksPrefix(upload.ID)); err != nil {
+		log.Printf("WARN: failed to delete chunks of upload %v: %v", upload.ID, err)
+	}
+	if failOnError(w, tx.DeleteResumableUpload(upload.ID), "failed to delete upload", http.StatusInternalServerError) {
+		return
+	}
+	if failOnError(w, tx.Commit().Error, "failed to delete upload", http.StatusInternalServerError) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExpireUpload - deletes resumable upload with its received bytes after TUS_EXPIRATION
+func (appCtx *CleanupTaskCtx) ExpireUpload(job *work.Job) error {
+	var id = job.ArgString("id")
+	if err := job.ArgError(); err != nil {
+		return err
+	}
+
+	// upload is locked, so chunks are not deleted while request writes or assembles them,
+	// locked upload is expired when job is retried
+	var tx, _, err = appCtx.DB.LockResumableUpload(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Printf("WARN: failed to lock expired upload %v: %v", id, err)
+		return err
+	}
+	defer tx.Rollback()
+	if err = appCtx.ImageService.DeleteStagedFolder(appCtx.chunksPrefix(id)); err != nil {
+		log.Printf("ERROR: failed to delete chunks of upload %v: %v", id, err)
+		return err
+	}
+	if err = tx.DeleteResumableUpload(id); err != nil {
+		return err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return err
+	}
+
+	log.Printf("CLEANUP_POOL: resumable upload %v expired", id)
+	return nil
+}
@@ -0,0 +1,41 @@
+package louis
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	var encode = base64.StdEncoding.EncodeToString
+	metadata, err := parseUploadMetadata("key " + encode([]byte("avatar")) + ", tags " + encode([]byte("a,b")) + ",is_confidential")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"key": "avatar", "tags": "a,b", "is_confidential": ""}, metadata)
+
+	metadata, err = parseUploadMetadata("")
+	assert.NoError(err)
+	assert.Empty(metadata)
+
+	_, err = parseUploadMetadata("key not-base64!")
+	assert.Error(err)
+
+	_, err = parseUploadMetadata("key a b")
+	assert.Error(err)
+}
+
+func TestUploadArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	var encode = func(value string) string { return base64.StdEncoding.EncodeToString([]byte(value)) }
+	args, err := uploadArgs("key " + encode("avatar") + ",tags " + encode("a, b") + ",cropPoints " + encode("0,0,10,20"))
+	assert.NoError(err)
+	assert.Equal("avatar", args.imageKey)
+	assert.Equal([]string{"a", "b"}, args.tags)
+	assert.Equal(10, args.cropSquare.BottomRightPoint.X)
+	assert.Equal(20, args.cropSquare.BottomRightPoint.Y)
+
+	_, err = uploadArgs("cropPoints " + encode("0,0,10"))
+	assert.Error(err)
+}
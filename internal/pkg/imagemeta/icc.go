@@ -0,0 +1,66 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+)
+
+const iccHeaderSize = 128
+
+// ProfileDescription - returns description of ICC profile, e.g. "Display P3" or "sRGB IEC61966-2.1"
+func ProfileDescription(icc []byte) string {
+	if len(icc) < iccHeaderSize+4 {
+		return ""
+	}
+	var count = int(binary.BigEndian.Uint32(icc[iccHeaderSize:]))
+	for i := 0; i < count; i++ {
+		var entry = iccHeaderSize + 4 + 12*i
+		if entry+12 > len(icc) {
+			return ""
+		}
+		if string(icc[entry:entry+4]) != "desc" {
+			continue
+		}
+		var offset = int(binary.BigEndian.Uint32(icc[entry+4:]))
+		var size = int(binary.BigEndian.Uint32(icc[entry+8:]))
+		if offset < 0 || size < 12 || offset+size > len(icc) || offset+size < offset {
+			return ""
+		}
+		return readDescription(icc[offset : offset+size])
+	}
+	return ""
+}
+
+func readDescription(tag []byte) string {
+	switch string(tag[:4]) {
+	case "desc":
+		// ICC v2 textDescriptionType
+		var length = int(binary.BigEndian.Uint32(tag[8:]))
+		if length <= 0 || 12+length > len(tag) {
+			return ""
+		}
+		return strings.TrimSpace(strings.TrimRight(string(tag[12:12+length]), "\x00"))
+	case "mluc":
+		// ICC v4 multiLocalizedUnicodeType, first record is used
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		var length = int(binary.BigEndian.Uint32(tag[20:]))
+		var offset = int(binary.BigEndian.Uint32(tag[24:]))
+		if length < 0 || offset < 0 || offset+length > len(tag) {
+			return ""
+		}
+		var units = make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+		return strings.TrimSpace(strings.TrimRight(string(utf16.Decode(units)), "\x00"))
+	}
+	return ""
+}
+
+// IsSRGBProfile - reports whether profile describes sRGB color space
+func IsSRGBProfile(icc []byte) bool {
+	return strings.Contains(strings.ToLower(ProfileDescription(icc)), "srgb")
+}
@@ -0,0 +1,59 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildICC - returns profile with single desc tag
+func buildICC(tag []byte) []byte {
+	var icc = make([]byte, iccHeaderSize+4+12)
+	copy(icc[16:], "RGB ")
+	binary.BigEndian.PutUint32(icc[iccHeaderSize:], 1)
+	copy(icc[iccHeaderSize+4:], "desc")
+	binary.BigEndian.PutUint32(icc[iccHeaderSize+8:], uint32(len(icc)))
+	binary.BigEndian.PutUint32(icc[iccHeaderSize+12:], uint32(len(tag)))
+	return append(icc, tag...)
+}
+
+func TestProfileDescriptionV2(t *testing.T) {
+	var text = "sRGB IEC61966-2.1\x00"
+	var tag = make([]byte, 12, 12+len(text)+16)
+	copy(tag, "desc")
+	binary.BigEndian.PutUint32(tag[8:], uint32(len(text)))
+	tag = append(tag, text...)
+	tag = append(tag, make([]byte, 16)...)
+
+	var icc = buildICC(tag)
+	assert.Equal(t, "sRGB IEC61966-2.1", ProfileDescription(icc))
+	assert.True(t, IsSRGBProfile(icc))
+}
+
+func TestProfileDescriptionV4(t *testing.T) {
+	var text = utf16.Encode([]rune("Display P3"))
+	var tag = make([]byte, 28, 28+2*len(text))
+	copy(tag, "mluc")
+	binary.BigEndian.PutUint32(tag[8:], 1)
+	binary.BigEndian.PutUint32(tag[12:], 12)
+	copy(tag[16:], "enUS")
+	binary.BigEndian.PutUint32(tag[20:], uint32(2*len(text)))
+	binary.BigEndian.PutUint32(tag[24:], 28)
+	for _, unit := range text {
+		tag = append(tag, byte(unit>>8), byte(unit))
+	}
+
+	var icc = buildICC(tag)
+	assert.Equal(t, "Display P3", ProfileDescription(icc))
+	assert.False(t, IsSRGBProfile(icc))
+}
+
+func TestProfileDescriptionInvalid(t *testing.T) {
+	assert.Equal(t, "", ProfileDescription(nil))
+	assert.Equal(t, "", ProfileDescription(make([]byte, iccHeaderSize)))
+
+	var icc = buildICC([]byte("desc\x00\x00\x00\x00\xff\xff\xff\xff"))
+	assert.Equal(t, "", ProfileDescription(icc))
+}
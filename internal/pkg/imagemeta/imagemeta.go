@@ -0,0 +1,319 @@
+// Package imagemeta extracts EXIF and IPTC metadata of source images
+// and writes selected metadata back into transformed jpeg images
+package imagemeta
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Metadata - structured record of source image metadata
+type Metadata struct {
+	// CaptureDate - EXIF DateTimeOriginal in "2006-01-02T15:04:05" format, with offset if it is known
+	CaptureDate string   `json:"captureDate,omitempty"`
+	Make        string   `json:"make,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Software    string   `json:"software,omitempty"`
+	Orientation int      `json:"orientation,omitempty"`
+	Artist      string   `json:"artist,omitempty"`
+	Copyright   string   `json:"copyright,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	GPS         *GPS     `json:"gps,omitempty"`
+	// ColorSpace - libvips interpretation of source image, e.g. "srgb", "cmyk" or "b-w"
+	ColorSpace string `json:"colorSpace,omitempty"`
+	// ICCProfile - description of ICC profile embedded into source image
+	ICCProfile string `json:"iccProfile,omitempty"`
+}
+
+// GPS - location where image was taken
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// Value - implements driver.Valuer
+func (m *Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan - implements sql.Scanner
+func (m *Metadata) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case nil:
+		*m = Metadata{}
+		return nil
+	case []byte:
+		return json.Unmarshal(data, m)
+	case string:
+		return json.Unmarshal([]byte(data), m)
+	}
+	return fmt.Errorf("can not scan %T into metadata", src)
+}
+
+// jpeg markers
+const (
+	markerSOI   = 0xD8
+	markerSOS   = 0xDA
+	markerEOI   = 0xD9
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP13 = 0xED
+)
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+	iccHeader       = []byte("ICC_PROFILE\x00")
+)
+
+type segment struct {
+	marker byte
+	// offset of marker in jpeg buffer
+	offset int
+	data   []byte
+}
+
+// jpegSegments - returns metadata segments placed before image data
+func jpegSegments(buf []byte) ([]segment, bool) {
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != markerSOI {
+		return nil, false
+	}
+	var segments []segment
+	var pos = 2
+	for pos+4 <= len(buf) {
+		if buf[pos] != 0xFF {
+			break
+		}
+		var marker = buf[pos+1]
+		if marker == 0xFF {
+			// fill byte
+			pos++
+			continue
+		}
+		if marker == markerSOS || marker == markerEOI {
+			break
+		}
+		var length = int(binary.BigEndian.Uint16(buf[pos+2:]))
+		if length < 2 || pos+2+length > len(buf) {
+			break
+		}
+		segments = append(segments, segment{marker: marker, offset: pos, data: buf[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+	return segments, true
+}
+
+// Extract - reads EXIF and IPTC metadata of jpeg or tiff image,
+// returns nil if image has no known metadata
+func Extract(buf []byte) (*Metadata, error) {
+	var m = &Metadata{}
+	var found bool
+
+	if segments, isJPEG := jpegSegments(buf); isJPEG {
+		for _, s := range segments {
+			switch {
+			case s.marker == markerAPP1 && bytes.HasPrefix(s.data, exifHeader):
+				if err := m.readEXIF(s.data[len(exifHeader):]); err != nil {
+					return nil, err
+				}
+				found = true
+			case s.marker == markerAPP13 && bytes.HasPrefix(s.data, photoshopHeader):
+				if m.readPhotoshop(s.data[len(photoshopHeader):]) {
+					found = true
+				}
+			}
+		}
+	} else if _, _, err := newTIFFReader(buf); err == nil {
+		if err := m.readEXIF(buf); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+
+	if !found || m.isEmpty() {
+		return nil, nil
+	}
+	return m, nil
+}
+
+func (m *Metadata) isEmpty() bool {
+	return reflect.DeepEqual(*m, Metadata{})
+}
+
+// setString - sets non empty value, so EXIF fields do not erase ones read from IPTC
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+func (m *Metadata) readEXIF(buf []byte) error {
+	var r, offset, err = newTIFFReader(buf)
+	if err != nil {
+		return err
+	}
+	ifd0, err := r.readIFD(offset)
+	if err != nil {
+		return err
+	}
+
+	setString(&m.Make, r.string(ifd0[tagMake]))
+	setString(&m.Model, r.string(ifd0[tagModel]))
+	setString(&m.Software, r.string(ifd0[tagSoftware]))
+	setString(&m.Artist, r.string(ifd0[tagArtist]))
+	// copyright may contain photographer and editor parts separated by NUL
+	setString(&m.Copyright, strings.Join(strings.FieldsFunc(r.string(ifd0[tagCopyright]), func(c rune) bool { return c == 0 }), "; "))
+	setString(&m.Description, r.string(ifd0[tagImageDescription]))
+	if orientation, ok := r.uint(ifd0[tagOrientation], 0); ok && orientation <= 8 {
+		m.Orientation = int(orientation)
+	}
+
+	if pointer, ok := r.uint(ifd0[tagExifIFD], 0); ok {
+		if exif, err := r.readIFD(pointer); err == nil {
+			m.CaptureDate = captureDate(r.string(exif[tagDateTimeOriginal]), r.string(exif[tagOffsetTimeOrignal]))
+		}
+	}
+	if pointer, ok := r.uint(ifd0[tagGPSIFD], 0); ok {
+		if gps, err := r.readIFD(pointer); err == nil {
+			m.GPS = readGPS(r, gps)
+		}
+	}
+	return nil
+}
+
+// captureDate - converts EXIF "2006:01:02 15:04:05" date to ISO 8601 format
+func captureDate(date, offset string) string {
+	if len(date) != 19 || strings.HasPrefix(date, "0000") {
+		return ""
+	}
+	var iso = strings.Replace(date[:10], ":", "-", 2) + "T" + date[11:]
+	if len(offset) == 6 {
+		iso += offset
+	}
+	return iso
+}
+
+func readGPS(r *tiffReader, ifd map[uint16]tiffEntry) *GPS {
+	var coordinate = func(tag uint16, negativeRef string, refTag uint16) (float64, bool) {
+		var e, exists = ifd[tag]
+		if !exists {
+			return 0, false
+		}
+		var value float64
+		var divisor = 1.0
+		for i := 0; i < 3; i++ {
+			var part, ok = r.rational(e, i)
+			if !ok {
+				return 0, false
+			}
+			value += part / divisor
+			divisor *= 60
+		}
+		if r.string(ifd[refTag]) == negativeRef {
+			value = -value
+		}
+		return value, true
+	}
+
+	var latitude, latOk = coordinate(tagGPSLatitude, "S", tagGPSLatitudeRef)
+	var longitude, lonOk = coordinate(tagGPSLongitude, "W", tagGPSLongitudeRef)
+	if !latOk || !lonOk {
+		return nil
+	}
+	var gps = &GPS{Latitude: latitude, Longitude: longitude}
+	if altitude, ok := r.rational(ifd[tagGPSAltitude], 0); ok {
+		gps.Altitude = altitude
+		if ref, ok := r.uint(ifd[tagGPSAltitudeRef], 0); ok && ref == 1 {
+			gps.Altitude = -altitude
+		}
+	}
+	return gps
+}
+
+// IPTC datasets of application record
+const (
+	iptcRecord     = 2
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcByLine     = 80
+	iptcCopyright  = 116
+	iptcCaption    = 120
+)
+
+// readPhotoshop - reads IPTC block from photoshop image resources
+func (m *Metadata) readPhotoshop(buf []byte) bool {
+	var found bool
+	for len(buf) >= 12 && string(buf[:4]) == "8BIM" {
+		var id = binary.BigEndian.Uint16(buf[4:6])
+		// pascal string name padded to even length
+		var nameLength = int(buf[6]) + 1
+		nameLength += nameLength % 2
+		var pos = 6 + nameLength
+		if pos+4 > len(buf) {
+			break
+		}
+		var size = int(binary.BigEndian.Uint32(buf[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(buf) {
+			break
+		}
+		if id == 0x0404 {
+			m.readIPTC(buf[pos : pos+size])
+			found = true
+		}
+		pos += size + size%2
+		if pos > len(buf) {
+			break
+		}
+		buf = buf[pos:]
+	}
+	return found
+}
+
+// readIPTC - reads IPTC-IIM datasets, EXIF values take precedence over IPTC ones
+func (m *Metadata) readIPTC(buf []byte) {
+	for len(buf) >= 5 && buf[0] == 0x1C {
+		var record, dataset = buf[1], buf[2]
+		var size = int(binary.BigEndian.Uint16(buf[3:5]))
+		if size&0x8000 != 0 || 5+size > len(buf) {
+			// extended datasets are not used by fields we need
+			return
+		}
+		var value = strings.TrimSpace(string(buf[5 : 5+size]))
+		buf = buf[5+size:]
+		if record != iptcRecord || value == "" {
+			continue
+		}
+
+		switch dataset {
+		case iptcObjectName:
+			m.Title = value
+		case iptcKeywords:
+			m.Keywords = append(m.Keywords, value)
+		case iptcByLine:
+			if m.Artist == "" {
+				m.Artist = value
+			}
+		case iptcCopyright:
+			if m.Copyright == "" {
+				m.Copyright = value
+			}
+		case iptcCaption:
+			if m.Description == "" {
+				m.Description = value
+			}
+		}
+	}
+}
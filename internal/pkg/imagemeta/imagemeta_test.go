@@ -0,0 +1,190 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+	// index of IFD which offset should be written as value
+	ifd int
+}
+
+// buildTIFF - lays out IFDs one after another, values which do not fit into entry follow each IFD
+func buildTIFF(ifds ...[]testEntry) []byte {
+	var order = binary.LittleEndian
+	var offsets = make([]int, len(ifds))
+	var size = 8
+	for i, ifd := range ifds {
+		offsets[i] = size
+		size += 2 + 12*len(ifd) + 4
+		for _, e := range ifd {
+			if len(e.data) > 4 {
+				size += len(e.data)
+			}
+		}
+	}
+
+	var buf = make([]byte, 8, size)
+	copy(buf, "II")
+	order.PutUint16(buf[2:], 42)
+	order.PutUint32(buf[4:], uint32(offsets[0]))
+	for _, ifd := range ifds {
+		var dataOffset = len(buf) + 2 + 12*len(ifd) + 4
+		var header = make([]byte, 2+12*len(ifd)+4)
+		var values []byte
+		order.PutUint16(header, uint16(len(ifd)))
+		for i, e := range ifd {
+			var entry = header[2+12*i:]
+			order.PutUint16(entry[0:], e.tag)
+			order.PutUint16(entry[2:], e.typ)
+			order.PutUint32(entry[4:], e.count)
+			switch {
+			case e.ifd > 0:
+				order.PutUint32(entry[8:], uint32(offsets[e.ifd]))
+			case len(e.data) <= 4:
+				copy(entry[8:], e.data)
+			default:
+				order.PutUint32(entry[8:], uint32(dataOffset+len(values)))
+				values = append(values, e.data...)
+			}
+		}
+		buf = append(append(buf, header...), values...)
+	}
+	return buf
+}
+
+func ascii(value string) testEntry {
+	return testEntry{typ: typeASCII, count: uint32(len(value) + 1), data: append([]byte(value), 0)}
+}
+
+func tagged(tag uint16, e testEntry) testEntry {
+	e.tag = tag
+	return e
+}
+
+func rationals(values ...uint32) testEntry {
+	var data = make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+	return testEntry{typ: typeRational, count: uint32(len(values) / 2), data: data}
+}
+
+func testJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	return buf.Bytes()
+}
+
+// withSegments - inserts segments right after SOI marker
+func withSegments(jpg []byte, segments ...[]byte) []byte {
+	var out = bytes.NewBuffer(append([]byte{}, jpg[:2]...))
+	for _, s := range segments {
+		writeSegment(out, s[0], s[1:])
+	}
+	out.Write(jpg[2:])
+	return out.Bytes()
+}
+
+func iptcDataset(dataset byte, value string) []byte {
+	return append([]byte{0x1C, iptcRecord, dataset, byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+func TestExtract(t *testing.T) {
+	var exif = buildTIFF(
+		[]testEntry{
+			tagged(tagMake, ascii("Canon")),
+			tagged(tagModel, ascii("EOS 5D")),
+			{tag: tagOrientation, typ: typeShort, count: 1, data: []byte{6, 0}},
+			{tag: tagExifIFD, typ: typeLong, count: 1, ifd: 1},
+			{tag: tagGPSIFD, typ: typeLong, count: 1, ifd: 2},
+		},
+		[]testEntry{
+			tagged(tagDateTimeOriginal, ascii("2019:05:17 13:45:10")),
+			tagged(tagOffsetTimeOrignal, ascii("+03:00")),
+		},
+		[]testEntry{
+			tagged(tagGPSLatitudeRef, ascii("N")),
+			tagged(tagGPSLatitude, rationals(55, 1, 45, 1, 36, 1)),
+			tagged(tagGPSLongitudeRef, ascii("W")),
+			tagged(tagGPSLongitude, rationals(37, 1, 30, 1, 0, 1)),
+		},
+	)
+
+	var iptc = append(iptcDataset(iptcObjectName, "Red dress"), iptcDataset(iptcKeywords, "dress")...)
+	iptc = append(iptc, iptcDataset(iptcKeywords, "red")...)
+	iptc = append(iptc, iptcDataset(iptcByLine, "John Doe")...)
+	iptc = append(iptc, iptcDataset(iptcCopyright, "KazanExpress")...)
+	var resource = append([]byte("8BIM\x04\x04\x00\x00"), 0, 0, 0, byte(len(iptc)))
+	resource = append(resource, iptc...)
+
+	var jpg = withSegments(testJPEG(t),
+		append([]byte{markerAPP1}, append(exifHeader, exif...)...),
+		append([]byte{markerAPP13}, append(photoshopHeader, resource...)...),
+	)
+
+	var m, err = Extract(jpg)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+
+	assert.Equal(t, "Canon", m.Make)
+	assert.Equal(t, "EOS 5D", m.Model)
+	assert.Equal(t, 6, m.Orientation)
+	assert.Equal(t, "2019-05-17T13:45:10+03:00", m.CaptureDate)
+	assert.Equal(t, "Red dress", m.Title)
+	assert.Equal(t, []string{"dress", "red"}, m.Keywords)
+	assert.Equal(t, "John Doe", m.Artist)
+	assert.Equal(t, "KazanExpress", m.Copyright)
+	require.NotNil(t, m.GPS)
+	assert.InDelta(t, 55.76, m.GPS.Latitude, 0.0001)
+	assert.InDelta(t, -37.5, m.GPS.Longitude, 0.0001)
+}
+
+func TestExtractWithoutMetadata(t *testing.T) {
+	var m, err = Extract(testJPEG(t))
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	m, err = Extract([]byte("not an image"))
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+}
+
+func TestExtractInvalidEXIF(t *testing.T) {
+	var jpg = withSegments(testJPEG(t), append([]byte{markerAPP1}, append(exifHeader, "MM\x00\x2a\xff\xff\xff\xff"...)...))
+	var _, err = Extract(jpg)
+	assert.Error(t, err)
+}
+
+func TestEmbed(t *testing.T) {
+	var icc = bytes.Repeat([]byte{1, 2, 3}, maxICCChunk/2)
+	var out, err = Embed(testJPEG(t), &Preserved{Artist: "John Doe", Copyright: "(c) KazanExpress", ICC: icc})
+	require.NoError(t, err)
+
+	_, err = jpeg.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+
+	m, err := Extract(out)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, "John Doe", m.Artist)
+	assert.Equal(t, "(c) KazanExpress", m.Copyright)
+	assert.Nil(t, m.GPS)
+	assert.Equal(t, icc, ICCProfile(out))
+}
+
+func TestEmbedNotJPEG(t *testing.T) {
+	var _, err = Embed([]byte("\x89PNG"), &Preserved{Copyright: "KazanExpress"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// max size of ICC profile chunk which fits into one APP2 segment
+const maxICCChunk = 0xFFFF - 2 - 12 - 2
+
+var errNotJPEG = errors.New("image is not jpeg")
+
+// ICCProfile - returns ICC profile embedded into jpeg image, nil if there is no profile
+func ICCProfile(buf []byte) []byte {
+	var segments, isJPEG = jpegSegments(buf)
+	if !isJPEG {
+		return nil
+	}
+
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	for _, s := range segments {
+		if s.marker == markerAPP2 && bytes.HasPrefix(s.data, iccHeader) && len(s.data) > len(iccHeader)+2 {
+			var header = s.data[len(iccHeader):]
+			chunks = append(chunks, chunk{seq: header[0], data: header[2:]})
+		}
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+
+	var profile []byte
+	for _, c := range chunks {
+		profile = append(profile, c.data...)
+	}
+	return profile
+}
+
+// Preserved - metadata written into transformed image
+type Preserved struct {
+	Artist    string
+	Copyright string
+	ICC       []byte
+}
+
+// IsEmpty - reports whether there is nothing to write
+func (p *Preserved) IsEmpty() bool {
+	return p.Artist == "" && p.Copyright == "" && len(p.ICC) == 0
+}
+
+// Embed - writes preserved metadata into jpeg image which has no metadata,
+// segments are placed right after JFIF header as required by EXIF
+func Embed(buf []byte, p *Preserved) ([]byte, error) {
+	var segments, isJPEG = jpegSegments(buf)
+	if !isJPEG {
+		return nil, errNotJPEG
+	}
+	if p.IsEmpty() {
+		return buf, nil
+	}
+
+	var insertAt = 2
+	if len(segments) > 0 && segments[0].marker == markerAPP0 {
+		insertAt = segments[0].offset + 4 + len(segments[0].data)
+	}
+
+	var out = bytes.NewBuffer(make([]byte, 0, len(buf)+len(p.ICC)+1024))
+	out.Write(buf[:insertAt])
+
+	if p.Artist != "" || p.Copyright != "" {
+		var w tiffWriter
+		if p.Artist != "" {
+			w.addString(tagArtist, p.Artist)
+		}
+		if p.Copyright != "" {
+			w.addString(tagCopyright, p.Copyright)
+		}
+		var exif = append(append([]byte{}, exifHeader...), w.bytes()...)
+		if len(exif) > 0xFFFF-2 {
+			return nil, errors.New("exif data is too large")
+		}
+		writeSegment(out, markerAPP1, exif)
+	}
+
+	var chunksCount = (len(p.ICC) + maxICCChunk - 1) / maxICCChunk
+	if chunksCount > 255 {
+		return nil, errors.New("icc profile is too large")
+	}
+	for i := 0; i < chunksCount; i++ {
+		var end = (i + 1) * maxICCChunk
+		if end > len(p.ICC) {
+			end = len(p.ICC)
+		}
+		var data = append(append([]byte{}, iccHeader...), byte(i+1), byte(chunksCount))
+		writeSegment(out, markerAPP2, append(data, p.ICC[i*maxICCChunk:end]...))
+	}
+
+	out.Write(buf[insertAt:])
+	return out.Bytes(), nil
+}
+
+func writeSegment(out *bytes.Buffer, marker byte, data []byte) {
+	var header = []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)+2))
+	out.Write(header)
+	out.Write(data)
+}
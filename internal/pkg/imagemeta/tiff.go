@@ -0,0 +1,204 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// TIFF tags used by louis
+const (
+	tagImageWidth        = 0x0100
+	tagImageLength       = 0x0101
+	tagImageDescription  = 0x010E
+	tagMake              = 0x010F
+	tagModel             = 0x0110
+	tagOrientation       = 0x0112
+	tagSoftware          = 0x0131
+	tagArtist            = 0x013B
+	tagCopyright         = 0x8298
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagDateTimeOriginal  = 0x9003
+	tagOffsetTimeOrignal = 0x9011
+	tagGPSLatitudeRef    = 0x0001
+	tagGPSLatitude       = 0x0002
+	tagGPSLongitudeRef   = 0x0003
+	tagGPSLongitude      = 0x0004
+	tagGPSAltitudeRef    = 0x0005
+	tagGPSAltitude       = 0x0006
+)
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+var typeSizes = map[uint16]int{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSLong:     4,
+	typeSRational: 8,
+}
+
+var errInvalidTIFF = errors.New("invalid tiff structure")
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+// tiffReader - reads IFDs of TIFF structure, which is used by EXIF as well
+type tiffReader struct {
+	buf   []byte
+	order binary.ByteOrder
+}
+
+func newTIFFReader(buf []byte) (*tiffReader, uint32, error) {
+	if len(buf) < 8 {
+		return nil, 0, errInvalidTIFF
+	}
+	var r = &tiffReader{buf: buf}
+	switch string(buf[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, 0, errInvalidTIFF
+	}
+	if r.order.Uint16(buf[2:4]) != 42 {
+		return nil, 0, errInvalidTIFF
+	}
+	return r, r.order.Uint32(buf[4:8]), nil
+}
+
+// readIFD - returns entries of IFD placed at given offset
+func (r *tiffReader) readIFD(offset uint32) (map[uint16]tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(r.buf)) {
+		return nil, errInvalidTIFF
+	}
+	var count = int(r.order.Uint16(r.buf[offset:]))
+	var start = int(offset) + 2
+	if start+count*12 > len(r.buf) {
+		return nil, errInvalidTIFF
+	}
+
+	var entries = make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		var raw = r.buf[start+i*12 : start+(i+1)*12]
+		var e = tiffEntry{
+			typ:   r.order.Uint16(raw[2:4]),
+			count: r.order.Uint32(raw[4:8]),
+		}
+		var size, known = typeSizes[e.typ]
+		if !known {
+			continue
+		}
+		var length = uint64(size) * uint64(e.count)
+		if length <= 4 {
+			e.data = raw[8 : 8+length]
+		} else {
+			var valueOffset = uint64(r.order.Uint32(raw[8:12]))
+			if valueOffset+length > uint64(len(r.buf)) {
+				continue
+			}
+			e.data = r.buf[valueOffset : valueOffset+length]
+		}
+		entries[r.order.Uint16(raw[0:2])] = e
+	}
+	return entries, nil
+}
+
+func (r *tiffReader) uint(e tiffEntry, index int) (uint32, bool) {
+	switch e.typ {
+	case typeByte, typeUndefined:
+		if index < len(e.data) {
+			return uint32(e.data[index]), true
+		}
+	case typeShort:
+		if 2*index+2 <= len(e.data) {
+			return uint32(r.order.Uint16(e.data[2*index:])), true
+		}
+	case typeLong, typeSLong:
+		if 4*index+4 <= len(e.data) {
+			return r.order.Uint32(e.data[4*index:]), true
+		}
+	}
+	return 0, false
+}
+
+func (r *tiffReader) rational(e tiffEntry, index int) (float64, bool) {
+	if (e.typ != typeRational && e.typ != typeSRational) || 8*index+8 > len(e.data) {
+		return 0, false
+	}
+	var num = r.order.Uint32(e.data[8*index:])
+	var den = r.order.Uint32(e.data[8*index+4:])
+	if den == 0 {
+		return 0, false
+	}
+	if e.typ == typeSRational {
+		return float64(int32(num)) / float64(int32(den)), true
+	}
+	return float64(num) / float64(den), true
+}
+
+func (r *tiffReader) string(e tiffEntry) string {
+	if e.typ != typeASCII && e.typ != typeUndefined {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.data), "\x00"))
+}
+
+// tiffWriter - builds little endian TIFF structure with single IFD of ASCII fields
+type tiffWriter struct {
+	tags   []uint16
+	values []string
+}
+
+func (w *tiffWriter) addString(tag uint16, value string) {
+	w.tags = append(w.tags, tag)
+	w.values = append(w.values, value)
+}
+
+func (w *tiffWriter) bytes() []byte {
+	var order = binary.LittleEndian
+	var ifdSize = 2 + 12*len(w.tags) + 4
+	var buf = make([]byte, 8+ifdSize)
+	copy(buf, "II")
+	order.PutUint16(buf[2:], 42)
+	order.PutUint32(buf[4:], 8)
+	order.PutUint16(buf[8:], uint16(len(w.tags)))
+
+	// tags should be sorted in ascending order, they are added in such order
+	for i, tag := range w.tags {
+		var value = append([]byte(w.values[i]), 0)
+		var entry = buf[10+12*i:]
+		order.PutUint16(entry[0:], tag)
+		order.PutUint16(entry[2:], typeASCII)
+		order.PutUint32(entry[4:], uint32(len(value)))
+		if len(value) <= 4 {
+			copy(entry[8:12], value)
+			continue
+		}
+		order.PutUint32(entry[8:], uint32(len(buf)))
+		buf = append(buf, value...)
+		if len(buf)%2 == 1 {
+			// values should begin on word boundary
+			buf = append(buf, 0)
+		}
+	}
+	return buf
+}
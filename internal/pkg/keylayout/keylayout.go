@@ -0,0 +1,82 @@
+package keylayout
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Legacy - layout of images uploaded before layouts were configurable, objects are stored right under image key
+const Legacy = "{key}"
+
+// UntaggedSegment - value of {tag} for image without tags
+const UntaggedSegment = "untagged"
+
+var placeholderRe = regexp.MustCompile(`\{[^}]*\}`)
+
+// unsafeSegmentRe - chars which are replaced in {tag}, so it is one path segment which needs no URL escaping
+var unsafeSegmentRe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+var placeholders = map[string]bool{"{key}": true, "{shard}": true, "{tag}": true, "{user}": true, "{date}": true}
+
+// Layout - template of folder where objects of image are stored, placeholders:
+//
+//	{key} - image key, layout should end with it
+//	{shard} - two hex chars of sha1 of image key, spreads images over 256 prefixes
+//	{tag} - first tag of image or "untagged", chars other than letters, digits, ".", "_" and "-" are replaced by "_"
+//	{user} - id of account uploaded image
+//	{date} - upload date as yyyy/mm/dd
+type Layout struct {
+	template string
+}
+
+// New - parses and validates layout, empty template is Legacy
+func New(template string) (*Layout, error) {
+	if template == "" {
+		template = Legacy
+	}
+	if !strings.HasSuffix(template, "{key}") || strings.Count(template, "{key}") != 1 {
+		return nil, fmt.Errorf("key layout %q should end with {key} and contain it once", template)
+	}
+	if strings.HasPrefix(template, "/") || strings.Contains(template, "//") {
+		return nil, fmt.Errorf("key layout %q has empty path segment", template)
+	}
+	for _, p := range placeholderRe.FindAllString(template, -1) {
+		if !placeholders[p] {
+			return nil, fmt.Errorf("key layout %q has unknown placeholder %v", template, p)
+		}
+	}
+	return &Layout{template: template}, nil
+}
+
+// Folder - returns folder of image objects, nil layout is Legacy
+func (l *Layout) Folder(imageKey string, tags []string, userID int32, date time.Time) string {
+	if l == nil {
+		return imageKey
+	}
+	var sum = sha1.Sum([]byte(imageKey))
+	var tag = UntaggedSegment
+	if len(tags) > 0 && tags[0] != "" {
+		tag = tagSegment(tags[0])
+	}
+	return strings.NewReplacer(
+		"{key}", imageKey,
+		"{shard}", hex.EncodeToString(sum[:1]),
+		"{tag}", tag,
+		"{user}", strconv.Itoa(int(userID)),
+		"{date}", date.UTC().Format("2006/01/02"),
+	).Replace(l.template)
+}
+
+// tagSegment - makes tag safe path segment, so it can not add, remove or escape folders of layout
+func tagSegment(tag string) string {
+	tag = unsafeSegmentRe.ReplaceAllString(tag, "_")
+	if strings.Trim(tag, ".") == "" {
+		return strings.Repeat("_", len(tag))
+	}
+	return tag
+}
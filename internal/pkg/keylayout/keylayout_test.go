@@ -0,0 +1,52 @@
+package keylayout
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFolder(t *testing.T) {
+	assert := assert.New(t)
+
+	var date = time.Date(2019, 3, 7, 23, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+
+	var legacy *Layout
+	assert.Equal("abc", legacy.Folder("abc", nil, 1, date))
+
+	layout, err := New("")
+	assert.NoError(err)
+	assert.Equal("abc", layout.Folder("abc", []string{"product"}, 1, date))
+
+	layout, err = New("{shard}/{tag}/{user}/{date}/{key}")
+	assert.NoError(err)
+	assert.Equal("a9/product/7/2019/03/07/abc", layout.Folder("abc", []string{"product", "shop"}, 7, date))
+	assert.Equal("a9/untagged/7/2019/03/07/abc", layout.Folder("abc", nil, 7, date))
+}
+
+func TestFolderUnsafeTag(t *testing.T) {
+	assert := assert.New(t)
+
+	layout, err := New("{tag}/{key}")
+	assert.NoError(err)
+	for tag, folder := range map[string]string{
+		"a/b":      "a_b/abc",
+		"..":       "__/abc",
+		".":        "_/abc",
+		"../../x":  ".._.._x/abc",
+		"фото 1":   "_____1/abc",
+		"v1.0-new": "v1.0-new/abc",
+		"a?b#c%20": "a_b_c_20/abc",
+	} {
+		assert.Equal(folder, layout.Folder("abc", []string{tag}, 1, time.Now()), tag)
+	}
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, template := range []string{"{key}/{tag}", "{tag}", "{key}/{key}", "/{key}", "{tag}//{key}", "{month}/{key}"} {
+		_, err := New(template)
+		assert.Error(err, "layout %q should be invalid", template)
+	}
+}
@@ -0,0 +1,71 @@
+package phash
+
+import (
+	"image"
+	"math/bits"
+)
+
+// dHash description - http://www.hackerfactor.com/blog/index.php?/archives/529-Kind-of-Like-That.html
+
+const (
+	gridWidth  = 9
+	gridHeight = 8
+)
+
+// Bands - number of 16 bit bands of hash, images with distance less than Bands have at least one equal band
+const Bands = 4
+
+// DHash - calculates difference hash of image: image is reduced to 9x8 grayscale grid
+// and every bit tells if pixel is brighter than its right neighbour
+func DHash(img image.Image) uint64 {
+	var grid = grayGrid(img)
+	var hash uint64
+	for y := 0; y < gridHeight; y++ {
+		for x := 0; x < gridWidth-1; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance - returns hamming distance between hashes, number of different bits
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Band - returns i-th 16 bit part of hash starting from the highest bits
+func Band(hash uint64, i int) int {
+	return int(hash >> uint(16*(Bands-1-i)) & 0xffff)
+}
+
+// grayGrid - averages luminance of image areas covered by grid cells
+func grayGrid(img image.Image) [gridHeight][gridWidth]float64 {
+	var grid [gridHeight][gridWidth]float64
+	var counts [gridHeight][gridWidth]int
+	var bounds = img.Bounds()
+	var width, height = bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return grid
+	}
+	for y := 0; y < height; y++ {
+		var gy = y * gridHeight / height
+		for x := 0; x < width; x++ {
+			var gx = x * gridWidth / width
+			var r, g, b, _ = img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			// ITU-R BT.601 luma
+			grid[gy][gx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[gy][gx]++
+		}
+	}
+	for y := range grid {
+		for x := range grid[y] {
+			if counts[y][x] > 0 {
+				grid[y][x] /= float64(counts[y][x])
+			}
+		}
+	}
+	return grid
+}
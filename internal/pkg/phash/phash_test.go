@@ -0,0 +1,53 @@
+package phash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradient(width, height int, value func(x, y int) uint8) image.Image {
+	var img = image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.Gray{Y: value(x, y)})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	assert := assert.New(t)
+
+	var darker = gradient(90, 80, func(x, y int) uint8 { return uint8(255 - x*2) })
+	assert.Equal(^uint64(0), DHash(darker), "every pixel is brighter than its right neighbour")
+
+	var lighter = gradient(90, 80, func(x, y int) uint8 { return uint8(x * 2) })
+	assert.Equal(uint64(0), DHash(lighter))
+	assert.Equal(64, Distance(DHash(darker), DHash(lighter)))
+
+	var solid = gradient(10, 10, func(x, y int) uint8 { return 128 })
+	assert.Equal(uint64(0), DHash(solid))
+	assert.Equal(uint64(0), DHash(image.NewGray(image.Rect(0, 0, 0, 0))))
+}
+
+func TestDHashScaled(t *testing.T) {
+	var value = func(size int) func(x, y int) uint8 {
+		return func(x, y int) uint8 { return uint8((x*7 + y*13) * 256 / (size * 20) % 256) }
+	}
+	var small = gradient(36, 32, value(36))
+	var large = gradient(360, 320, value(360))
+	assert.True(t, Distance(DHash(small), DHash(large)) <= 4, "scaled image should have close hash")
+}
+
+func TestBand(t *testing.T) {
+	assert := assert.New(t)
+
+	var hash uint64 = 0x0123456789abcdef
+	assert.Equal(0x0123, Band(hash, 0))
+	assert.Equal(0x4567, Band(hash, 1))
+	assert.Equal(0x89ab, Band(hash, 2))
+	assert.Equal(0xcdef, Band(hash, 3))
+	assert.Equal(3, Distance(0, 0x7))
+}
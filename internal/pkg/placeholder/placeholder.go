@@ -0,0 +1,54 @@
+package placeholder
+
+import (
+	"fmt"
+	"image"
+)
+
+// Placeholder - low quality representations of image shown while it's loading
+type Placeholder struct {
+	BlurHash      string `json:"blurhash"`
+	DominantColor string `json:"dominantColor"`
+	// Preview - tiny jpeg encoded as data URI
+	Preview string `json:"preview"`
+}
+
+// DominantColor - returns most frequent color of image in "#rrggbb" format,
+// similar colors are grouped together, transparent pixels are skipped
+func DominantColor(img image.Image) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	var buckets = make(map[int]*bucket)
+	var best *bucket
+
+	var bounds = img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var r, g, b, a = img.At(x, y).RGBA()
+			if a == 0 {
+				continue
+			}
+			// 4 most significant bits of each channel
+			var key = int(r>>12)<<8 | int(g>>12)<<4 | int(b>>12)
+			var bk, exists = buckets[key]
+			if !exists {
+				bk = new(bucket)
+				buckets[key] = bk
+			}
+			bk.count++
+			bk.r += int(r >> 8)
+			bk.g += int(g >> 8)
+			bk.b += int(b >> 8)
+			if best == nil || bk.count > best.count {
+				best = bk
+			}
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
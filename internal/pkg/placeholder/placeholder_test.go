@@ -0,0 +1,77 @@
+package placeholder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func filledImage(width, height int, fill func(x, y int) color.Color) image.Image {
+	var img = image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, fill(x, y))
+		}
+	}
+	return img
+}
+
+func TestBlurHashSolidColor(t *testing.T) {
+	assert := assert.New(t)
+
+	var img = filledImage(32, 24, func(x, y int) color.Color { return color.RGBA{255, 0, 0, 255} })
+
+	hash, err := BlurHash(img, 4, 3)
+	assert.NoError(err)
+	assert.Equal(4+2*4*3, len(hash))
+	// size flag: (4 - 1) + (3 - 1) * 9
+	assert.Equal(encode83(21, 1), hash[:1])
+	// dc component keeps the color
+	assert.Equal(encode83(0xff0000, 4), hash[2:6])
+	// green and blue channels of ac components are neutral
+	for i := 6; i < len(hash); i += 2 {
+		assert.Contains(base83Chars, hash[i:i+1])
+		assert.Equal(9*19+9, decode83(hash[i:i+2])%(19*19))
+	}
+}
+
+func decode83(str string) int {
+	var value int
+	for _, c := range str {
+		value = value*83 + strings.IndexRune(base83Chars, c)
+	}
+	return value
+}
+
+func TestBlurHashGradient(t *testing.T) {
+	assert := assert.New(t)
+
+	var img = filledImage(32, 32, func(x, y int) color.Color { return color.Gray{uint8(x * 8)} })
+
+	hash, err := BlurHash(img, 3, 3)
+	assert.NoError(err)
+	assert.Equal(4+2*3*3, len(hash))
+	assert.NotEqual(encode83(9*19*19+9*19+9, 2), hash[6:8], "horizontal component should not be neutral")
+
+	_, err = BlurHash(img, 0, 3)
+	assert.Error(err)
+	_, err = BlurHash(image.NewRGBA(image.Rect(0, 0, 0, 0)), 3, 3)
+	assert.Error(err)
+}
+
+func TestDominantColor(t *testing.T) {
+	assert := assert.New(t)
+
+	var img = filledImage(10, 10, func(x, y int) color.Color {
+		if x < 7 {
+			return color.RGBA{0x10, 0x80, 0xf0, 0xff}
+		}
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}
+	})
+	assert.Equal("#1080f0", DominantColor(img))
+
+	var transparent = filledImage(2, 2, func(x, y int) color.Color { return color.RGBA{} })
+	assert.Equal("", DominantColor(transparent))
+}
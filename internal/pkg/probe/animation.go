@@ -0,0 +1,79 @@
+package probe
+
+import "encoding/binary"
+
+// gifFrames - counts image descriptors of gif without decoding them
+func gifFrames(buf []byte) int {
+	if len(buf) < 13 {
+		return 0
+	}
+	var pos = 13
+	if buf[10]&0x80 != 0 {
+		// global color table
+		pos += 3 << (uint(buf[10]&0x07) + 1)
+	}
+
+	// skipBlocks - skips data sub-blocks which end with zero length block
+	var skipBlocks = func() bool {
+		for pos < len(buf) {
+			var size = int(buf[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var frames int
+	for pos < len(buf) {
+		switch buf[pos] {
+		case 0x21: // extension
+			pos += 2
+			if !skipBlocks() {
+				return frames
+			}
+		case 0x2C: // image descriptor
+			if pos+10 > len(buf) {
+				return frames
+			}
+			var flags = buf[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				// local color table
+				pos += 3 << (uint(flags&0x07) + 1)
+			}
+			// LZW minimum code size
+			pos++
+			if !skipBlocks() {
+				return frames
+			}
+			frames++
+		default: // trailer or garbage
+			return frames
+		}
+	}
+	return frames
+}
+
+// webpFrames - counts animation frames of extended webp, simple webp has single frame
+func webpFrames(buf []byte) int {
+	if len(buf) < 21 || string(buf[12:16]) != "VP8X" || buf[20]&0x02 == 0 {
+		return 1
+	}
+	var frames int
+	var chunks = buf[12:]
+	for len(chunks) >= 8 {
+		var size = int64(binary.LittleEndian.Uint32(chunks[4:]))
+		// chunks are padded to even size
+		var next = 8 + size + size%2
+		if string(chunks[:4]) == "ANMF" {
+			frames++
+		}
+		if next > int64(len(chunks)) {
+			break
+		}
+		chunks = chunks[next:]
+	}
+	return frames
+}
@@ -0,0 +1,97 @@
+package probe
+
+import "encoding/binary"
+
+// brands of ISO base media file format used by HEIF and AVIF images
+var heifBrands = map[string]string{
+	"heic": "heif",
+	"heix": "heif",
+	"heim": "heif",
+	"heis": "heif",
+	"hevc": "heif",
+	"hevx": "heif",
+	"mif1": "heif",
+	"msf1": "heif",
+	"avif": "avif",
+	"avis": "avif",
+}
+
+// heifFormat - returns "heif" or "avif" if buffer starts with ftyp box of such image
+func heifFormat(buf []byte) string {
+	if len(buf) < 16 || string(buf[4:8]) != "ftyp" {
+		return ""
+	}
+	var size = int(binary.BigEndian.Uint32(buf))
+	if size < 16 || size > len(buf) {
+		return ""
+	}
+	// major brand has priority, "mif1" may be followed by "avif" compatible brand
+	var format = heifBrands[string(buf[8:12])]
+	for offset := 16; offset+4 <= size; offset += 4 {
+		if brand := heifBrands[string(buf[offset:offset+4])]; brand == "avif" || format == "" {
+			format = brand
+		}
+	}
+	return format
+}
+
+// walkBoxes - calls fn for each box of buffer until it returns false
+func walkBoxes(buf []byte, fn func(boxType string, content []byte) bool) {
+	for len(buf) >= 8 {
+		var size = uint64(binary.BigEndian.Uint32(buf))
+		var header uint64 = 8
+		switch size {
+		case 0:
+			size = uint64(len(buf))
+		case 1:
+			if len(buf) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(buf[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(buf)) {
+			return
+		}
+		if !fn(string(buf[4:8]), buf[header:size]) {
+			return
+		}
+		buf = buf[size:]
+	}
+}
+
+// box - returns content of first box of given type
+func box(buf []byte, boxType string) []byte {
+	var found []byte
+	walkBoxes(buf, func(t string, content []byte) bool {
+		if t == boxType {
+			found = content
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// probeHEIF - reads dimensions from "ispe" properties of meta box,
+// primary image is the largest one, others are thumbnails or grid tiles
+func probeHEIF(buf []byte, format string) (*Info, error) {
+	var meta = box(buf, "meta")
+	if len(meta) < 4 {
+		return nil, errorf(CodeInvalidImage, "%v image has no meta box", format)
+	}
+
+	var info = &Info{Format: format}
+	// meta is full box, its children follow version and flags
+	walkBoxes(box(box(meta[4:], "iprp"), "ipco"), func(t string, content []byte) bool {
+		if t == "ispe" && len(content) >= 12 {
+			var width = int(binary.BigEndian.Uint32(content[4:]))
+			var height = int(binary.BigEndian.Uint32(content[8:]))
+			if int64(width)*int64(height) > info.Pixels() {
+				info.Width, info.Height = width, height
+			}
+		}
+		return true
+	})
+	return info, nil
+}
@@ -0,0 +1,81 @@
+package probe
+
+import "math"
+
+// Limits - restrictions of image dimensions, zero value means no restriction
+type Limits struct {
+	MaxPixels int64
+	MaxWidth  int
+	MaxHeight int
+	MinWidth  int
+	MinHeight int
+	// MinAspect and MaxAspect - range of width to height ratio
+	MinAspect float64
+	MaxAspect float64
+}
+
+func minPositive(a, b int64) int64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func max(a, b int) int {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func minPositiveFloat(a, b float64) float64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Merge - returns limits which satisfy both l and other
+func (l Limits) Merge(other Limits) Limits {
+	return Limits{
+		MaxPixels: minPositive(l.MaxPixels, other.MaxPixels),
+		MaxWidth:  int(minPositive(int64(l.MaxWidth), int64(other.MaxWidth))),
+		MaxHeight: int(minPositive(int64(l.MaxHeight), int64(other.MaxHeight))),
+		MinWidth:  max(l.MinWidth, other.MinWidth),
+		MinHeight: max(l.MinHeight, other.MinHeight),
+		MinAspect: math.Max(l.MinAspect, other.MinAspect),
+		MaxAspect: minPositiveFloat(l.MaxAspect, other.MaxAspect),
+	}
+}
+
+// Check - returns *Error if image does not fit limits
+func (l Limits) Check(info *Info) error {
+	if l.MaxPixels > 0 && info.Pixels() > l.MaxPixels {
+		return errorf(CodeTooManyPixels, "image has %v pixels, maximum is %v", info.Pixels(), l.MaxPixels)
+	}
+	// every frame of animation is decoded, so frames count against pixel limit too
+	if l.MaxPixels > 0 && info.DecodedPixels() > l.MaxPixels {
+		return errorf(CodeTooManyPixels, "animation has %v frames of %v pixels, maximum is %v pixels in total",
+			info.Frames, info.Pixels(), l.MaxPixels)
+	}
+	if l.MaxWidth > 0 && info.Width > l.MaxWidth {
+		return errorf(CodeTooWide, "image width is %v, maximum is %v", info.Width, l.MaxWidth)
+	}
+	if l.MaxHeight > 0 && info.Height > l.MaxHeight {
+		return errorf(CodeTooHigh, "image height is %v, maximum is %v", info.Height, l.MaxHeight)
+	}
+	if info.Width < l.MinWidth {
+		return errorf(CodeTooNarrow, "image width is %v, minimum is %v", info.Width, l.MinWidth)
+	}
+	if info.Height < l.MinHeight {
+		return errorf(CodeTooLow, "image height is %v, minimum is %v", info.Height, l.MinHeight)
+	}
+	var aspect = float64(info.Width) / float64(info.Height)
+	if aspect < l.MinAspect {
+		return errorf(CodeAspectRatio, "image aspect ratio %.3g is less than minimum %.3g", aspect, l.MinAspect)
+	}
+	if l.MaxAspect > 0 && aspect > l.MaxAspect {
+		return errorf(CodeAspectRatio, "image aspect ratio %.3g is greater than maximum %.3g", aspect, l.MaxAspect)
+	}
+	return nil
+}
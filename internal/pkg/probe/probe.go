@@ -0,0 +1,188 @@
+// Package probe detects format and dimensions of image reading only its header,
+// so image is checked against limits before it is decoded by libvips
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	// decoders used by image.DecodeConfig
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Error codes
+const (
+	CodeInvalidImage      = "invalid_image"
+	CodeUnsupportedFormat = "unsupported_format"
+	CodeTooManyPixels     = "too_many_pixels"
+	CodeTooWide           = "too_wide"
+	CodeTooHigh           = "too_high"
+	CodeTooNarrow         = "too_narrow"
+	CodeTooLow            = "too_low"
+	CodeAspectRatio       = "invalid_aspect_ratio"
+)
+
+// Error - describes why image is rejected
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorf(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Info - format and dimensions of image
+type Info struct {
+	Format string
+	Width  int
+	Height int
+	// Frames - number of frames of animated gif or webp, 1 for other images
+	Frames int
+}
+
+// IsAnimated - reports whether image has several frames
+func (info *Info) IsAnimated() bool {
+	return info.Frames > 1
+}
+
+// Pixels - returns number of pixels in image
+func (info *Info) Pixels() int64 {
+	return int64(info.Width) * int64(info.Height)
+}
+
+// DecodedPixels - returns number of pixels decoded for all frames of image
+func (info *Info) DecodedPixels() int64 {
+	if info.Frames > 1 {
+		return info.Pixels() * int64(info.Frames)
+	}
+	return info.Pixels()
+}
+
+// Probe - reads format and dimensions from image header
+func Probe(buf []byte) (*Info, error) {
+	var info *Info
+	var err error
+	switch {
+	case bytes.HasPrefix(buf, []byte("RIFF")) && len(buf) >= 12 && string(buf[8:12]) == "WEBP":
+		info, err = probeWebP(buf)
+	case bytes.HasPrefix(buf, []byte("II*\x00")) || bytes.HasPrefix(buf, []byte("MM\x00*")):
+		info, err = probeTIFF(buf)
+	case heifFormat(buf) != "":
+		info, err = probeHEIF(buf, heifFormat(buf))
+	default:
+		var config, format, decodeErr = image.DecodeConfig(bytes.NewReader(buf))
+		if decodeErr == image.ErrFormat {
+			return nil, errorf(CodeUnsupportedFormat, "unsupported image format")
+		}
+		if decodeErr != nil {
+			return nil, errorf(CodeInvalidImage, "invalid image - %v", decodeErr)
+		}
+		info = &Info{Format: format, Width: config.Width, Height: config.Height}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Width <= 0 || info.Height <= 0 {
+		return nil, errorf(CodeInvalidImage, "invalid image dimensions %vx%v", info.Width, info.Height)
+	}
+
+	switch info.Format {
+	case "gif":
+		info.Frames = gifFrames(buf)
+	case "webp":
+		info.Frames = webpFrames(buf)
+	}
+	if info.Frames < 1 {
+		info.Frames = 1
+	}
+	return info, nil
+}
+
+func probeWebP(buf []byte) (*Info, error) {
+	if len(buf) < 30 {
+		return nil, errorf(CodeInvalidImage, "invalid webp header")
+	}
+	var data = buf[20:]
+	switch string(buf[12:16]) {
+	case "VP8 ":
+		// lossy bitstream: 3 bytes of frame tag, start code and 14 bit dimensions
+		if !bytes.Equal(data[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return nil, errorf(CodeInvalidImage, "invalid webp vp8 start code")
+		}
+		return &Info{
+			Format: "webp",
+			Width:  int(binary.LittleEndian.Uint16(data[6:]) & 0x3fff),
+			Height: int(binary.LittleEndian.Uint16(data[8:]) & 0x3fff),
+		}, nil
+	case "VP8L":
+		// lossless bitstream: signature and 14 bit dimensions decreased by one
+		if data[0] != 0x2f {
+			return nil, errorf(CodeInvalidImage, "invalid webp vp8l signature")
+		}
+		var bits = binary.LittleEndian.Uint32(data[1:])
+		return &Info{
+			Format: "webp",
+			Width:  int(bits&0x3fff) + 1,
+			Height: int(bits>>14&0x3fff) + 1,
+		}, nil
+	case "VP8X":
+		// extended format: flags, reserved bytes and 24 bit canvas size decreased by one
+		return &Info{
+			Format: "webp",
+			Width:  int(uint24(data[4:])) + 1,
+			Height: int(uint24(data[7:])) + 1,
+		}, nil
+	}
+	return nil, errorf(CodeInvalidImage, "unknown webp chunk %q", buf[12:16])
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func probeTIFF(buf []byte) (*Info, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if buf[0] == 'M' {
+		order = binary.BigEndian
+	}
+	if len(buf) < 8 {
+		return nil, errorf(CodeInvalidImage, "invalid tiff header")
+	}
+	var offset = int64(order.Uint32(buf[4:]))
+	if offset+2 > int64(len(buf)) {
+		return nil, errorf(CodeInvalidImage, "invalid tiff ifd offset")
+	}
+	var count = int64(order.Uint16(buf[offset:]))
+	if offset+2+count*12 > int64(len(buf)) {
+		return nil, errorf(CodeInvalidImage, "invalid tiff ifd")
+	}
+
+	var info = &Info{Format: "tiff"}
+	for i := int64(0); i < count; i++ {
+		var entry = buf[offset+2+i*12:]
+		var value int
+		switch order.Uint16(entry[2:]) {
+		case 3: // SHORT
+			value = int(order.Uint16(entry[8:]))
+		case 4: // LONG
+			value = int(order.Uint32(entry[8:]))
+		default:
+			continue
+		}
+		switch order.Uint16(entry) {
+		case 0x0100:
+			info.Width = value
+		case 0x0101:
+			info.Height = value
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,219 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngHeader - returns png signature with IHDR chunk only, which is enough to read dimensions
+func pngHeader(width, height uint32) []byte {
+	var ihdr = make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12] = 8 // bit depth
+	ihdr[13] = 2 // truecolor
+
+	var buf = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d")
+	buf = append(buf, ihdr...)
+	var crc = make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(ihdr))
+	return append(buf, crc...)
+}
+
+func webpHeader(chunk string, data []byte) []byte {
+	var buf = append([]byte("RIFF\x00\x00\x00\x00WEBP"), chunk...)
+	buf = append(buf, 0, 0, 0, 0)
+	return append(buf, append(data, make([]byte, 16)...)...)
+}
+
+func TestProbeStdlibFormats(t *testing.T) {
+	var img = image.NewRGBA(image.Rect(0, 0, 30, 20))
+	var encoders = map[string]func(*bytes.Buffer) error{
+		"jpeg": func(b *bytes.Buffer) error { return jpeg.Encode(b, img, nil) },
+		"png":  func(b *bytes.Buffer) error { return png.Encode(b, img) },
+		"gif":  func(b *bytes.Buffer) error { return gif.Encode(b, img, nil) },
+	}
+	for format, encode := range encoders {
+		var buf bytes.Buffer
+		require.NoError(t, encode(&buf))
+		var info, err = Probe(buf.Bytes())
+		require.NoError(t, err, format)
+		assert.Equal(t, &Info{Format: format, Width: 30, Height: 20, Frames: 1}, info)
+	}
+}
+
+func TestProbeHeaderOnly(t *testing.T) {
+	var info, err = Probe(pngHeader(50000, 50000))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2500000000), info.Pixels())
+}
+
+func TestProbeWebP(t *testing.T) {
+	var lossy = []byte{0, 0, 0, 0x9d, 0x01, 0x2a, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(lossy[6:], 640)
+	binary.LittleEndian.PutUint16(lossy[8:], 480)
+	info, err := Probe(webpHeader("VP8 ", lossy))
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Format: "webp", Width: 640, Height: 480, Frames: 1}, info)
+
+	var lossless = []byte{0x2f, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(lossless[1:], (100-1)|(200-1)<<14)
+	info, err = Probe(webpHeader("VP8L", lossless))
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Format: "webp", Width: 100, Height: 200, Frames: 1}, info)
+
+	var extended = []byte{0, 0, 0, 0, 0xff, 0xff, 0, 0x0f, 0x27, 0}
+	info, err = Probe(webpHeader("VP8X", extended))
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Format: "webp", Width: 65536, Height: 10000, Frames: 1}, info)
+}
+
+func TestProbeTIFF(t *testing.T) {
+	var buf = []byte("II*\x00\x08\x00\x00\x00\x02\x00")
+	var entry = make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry, 0x0100)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], 1024)
+	buf = append(buf, entry...)
+	binary.LittleEndian.PutUint16(entry, 0x0101)
+	binary.LittleEndian.PutUint16(entry[2:], 4)
+	binary.LittleEndian.PutUint32(entry[8:], 768)
+	buf = append(buf, entry...)
+
+	var info, err = Probe(append(buf, 0, 0, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Format: "tiff", Width: 1024, Height: 768, Frames: 1}, info)
+}
+
+func TestProbeErrors(t *testing.T) {
+	var _, err = Probe([]byte("plain text is not an image"))
+	require.Error(t, err)
+	assert.Equal(t, CodeUnsupportedFormat, err.(*Error).Code)
+
+	_, err = Probe(pngHeader(100, 100)[:20])
+	require.Error(t, err)
+	assert.Equal(t, CodeInvalidImage, err.(*Error).Code)
+
+	_, err = Probe(webpHeader("VP8 ", make([]byte, 10)))
+	require.Error(t, err)
+	assert.Equal(t, CodeInvalidImage, err.(*Error).Code)
+}
+
+func TestLimits(t *testing.T) {
+	var limits = Limits{MaxPixels: 1000000, MaxWidth: 2000, MinWidth: 100, MinHeight: 100}
+
+	assert.NoError(t, limits.Check(&Info{Width: 1000, Height: 1000}))
+
+	var cases = map[string]*Info{
+		CodeTooManyPixels: {Width: 1500, Height: 1000},
+		CodeTooWide:       {Width: 2500, Height: 200},
+		CodeTooNarrow:     {Width: 50, Height: 1000},
+		CodeTooLow:        {Width: 1000, Height: 50},
+	}
+	for code, info := range cases {
+		var err = limits.Check(info)
+		require.Error(t, err, code)
+		assert.Equal(t, code, err.(*Error).Code)
+	}
+
+	var merged = limits.Merge(Limits{MaxPixels: 500000, MaxHeight: 1000, MinWidth: 50, MinHeight: 200})
+	assert.Equal(t, Limits{MaxPixels: 500000, MaxWidth: 2000, MaxHeight: 1000, MinWidth: 100, MinHeight: 200}, merged)
+	assert.Equal(t, CodeTooHigh, merged.Check(&Info{Width: 300, Height: 1200}).(*Error).Code)
+}
+
+func TestLimitsAnimationFrames(t *testing.T) {
+	var limits = Limits{MaxPixels: 1000000}
+
+	assert.NoError(t, limits.Check(&Info{Width: 100, Height: 100, Frames: 100}))
+
+	var err = limits.Check(&Info{Width: 100, Height: 100, Frames: 5000})
+	require.Error(t, err)
+	assert.Equal(t, CodeTooManyPixels, err.(*Error).Code)
+}
+
+func TestAspectLimits(t *testing.T) {
+	var banner = Limits{MinAspect: 4}.Merge(Limits{MinAspect: 3, MaxAspect: 8})
+	assert.Equal(t, Limits{MinAspect: 4, MaxAspect: 8}, banner)
+
+	assert.NoError(t, banner.Check(&Info{Width: 1240, Height: 200}))
+	assert.Equal(t, CodeAspectRatio, banner.Check(&Info{Width: 800, Height: 800}).(*Error).Code)
+	assert.Equal(t, CodeAspectRatio, banner.Check(&Info{Width: 1000, Height: 100}).(*Error).Code)
+}
+
+func isoBox(boxType string, content ...[]byte) []byte {
+	var buf = make([]byte, 8)
+	copy(buf[4:], boxType)
+	for _, c := range content {
+		buf = append(buf, c...)
+	}
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)))
+	return buf
+}
+
+func ispe(width, height uint32) []byte {
+	var content = make([]byte, 12)
+	binary.BigEndian.PutUint32(content[4:], width)
+	binary.BigEndian.PutUint32(content[8:], height)
+	return isoBox("ispe", content)
+}
+
+func TestProbeHEIF(t *testing.T) {
+	var properties = isoBox("iprp", isoBox("ipco", isoBox("hvcC", make([]byte, 4)), ispe(512, 512), ispe(4032, 3024), ispe(320, 240)))
+	var meta = isoBox("meta", make([]byte, 4), isoBox("hdlr", make([]byte, 20)), properties)
+
+	var heic = append(isoBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")), meta...)
+	var info, err = Probe(heic)
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Format: "heif", Width: 4032, Height: 3024, Frames: 1}, info)
+
+	var avif = append(isoBox("ftyp", []byte("mif1\x00\x00\x00\x00mif1avifmiaf")), meta...)
+	info, err = Probe(avif)
+	require.NoError(t, err)
+	assert.Equal(t, "avif", info.Format)
+
+	_, err = Probe(isoBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")))
+	require.Error(t, err)
+	assert.Equal(t, CodeInvalidImage, err.(*Error).Code)
+}
+
+func TestProbeAnimatedGIF(t *testing.T) {
+	var palette = []color.Color{color.Black, color.White}
+	var animation = &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 16, 16), palette))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, animation))
+
+	var info, err = Probe(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, 3, info.Frames)
+	assert.True(t, info.IsAnimated())
+}
+
+func TestProbeAnimatedWebP(t *testing.T) {
+	var extended = []byte{0x02, 0, 0, 0, 99, 0, 0, 99, 0, 0}
+	var buf = append([]byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00"), extended...)
+	buf = append(buf, "ANIM\x06\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)
+	for i := 0; i < 2; i++ {
+		// frame chunk of odd size is followed by padding byte
+		buf = append(buf, "ANMF\x03\x00\x00\x00\x01\x02\x03\x00"...)
+	}
+
+	var info, err = Probe(buf)
+	require.NoError(t, err)
+	assert.Equal(t, &Info{Format: "webp", Width: 100, Height: 100, Frames: 2}, info)
+}
@@ -0,0 +1,69 @@
+package publicurl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Template - builds public URLs of image objects instead of S3 locations, placeholders:
+//
+//	{cdn} - base URL of image tags or default one
+//	{key} - folder of image objects, image key with "/v<N>" for replaced versions
+//	{transform}, {ext} - transformation name and file extension
+//	{path} - full object key, "{key}/{transform}.{ext}"
+type Template struct {
+	template string
+	baseURL  string
+	tagHosts map[string]string
+}
+
+// New - creates template, tagHosts are "tag=base url" pairs, first matching tag of image wins
+func New(template, baseURL string, tagHosts []string) (*Template, error) {
+	var t = &Template{
+		template: template,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		tagHosts: make(map[string]string, len(tagHosts)),
+	}
+	for _, pair := range tagHosts {
+		var parts = strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid tag host %q, should be tag=url", pair)
+		}
+		t.tagHosts[parts[0]] = strings.TrimSuffix(parts[1], "/")
+	}
+	// URL should be unique for every object, so template should contain folder and transformation
+	if template != "" && !strings.Contains(template, "{path}") &&
+		!(strings.Contains(template, "{key}") && strings.Contains(template, "{transform}")) {
+		return nil, fmt.Errorf("template %q should contain {path} or both {key} and {transform}", template)
+	}
+	if template != "" && strings.Contains(template, "{cdn}") && t.baseURL == "" && len(t.tagHosts) == 0 {
+		return nil, fmt.Errorf("template %q uses {cdn}, but base url is not set", template)
+	}
+	return t, nil
+}
+
+// Enabled - if template is set, otherwise S3 locations are used as is
+func (t *Template) Enabled() bool {
+	return t != nil && t.template != ""
+}
+
+// Host - returns base URL of first image tag which has own host, default base URL otherwise
+func (t *Template) Host(tags []string) string {
+	for _, tag := range tags {
+		if host, ok := t.tagHosts[tag]; ok {
+			return host
+		}
+	}
+	return t.baseURL
+}
+
+// URL - returns public URL of object of transformation stored in folder
+func (t *Template) URL(tags []string, folder, transform, ext string) string {
+	return strings.NewReplacer(
+		"{cdn}", t.Host(tags),
+		"{key}", folder,
+		"{transform}", transform,
+		"{ext}", ext,
+		"{path}", folder+"/"+transform+"."+ext,
+	).Replace(t.template)
+}
@@ -0,0 +1,44 @@
+package publicurl
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpl, err := New("{cdn}/{key}/{transform}.{ext}", "https://cdn.example.com/", []string{"product=https://products.example.com"})
+	assert.NoError(err)
+	assert.True(tmpl.Enabled())
+
+	assert.Equal("https://cdn.example.com/abc/original.jpg", tmpl.URL(nil, "abc", "original", "jpg"))
+	assert.Equal("https://products.example.com/abc/v2/card.webp", tmpl.URL([]string{"shop", "product"}, "abc/v2", "card", "webp"))
+
+	tmpl, err = New("https://img.example.com/{path}?v=1", "", nil)
+	assert.NoError(err)
+	assert.Equal("https://img.example.com/abc/original.jpg?v=1", tmpl.URL(nil, "abc", "original", "jpg"))
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpl, err := New("", "", nil)
+	assert.NoError(err)
+	assert.False(tmpl.Enabled())
+
+	_, err = New("{cdn}/{path}", "", nil)
+	assert.Error(err, "base url is required by {cdn}")
+
+	_, err = New("{cdn}/{path}", "https://cdn.example.com", []string{"product"})
+	assert.Error(err, "tag host without url is invalid")
+
+	_, err = New("{cdn}/{key}.{ext}", "https://cdn.example.com", nil)
+	assert.Error(err, "template without {transform} is invalid")
+	_, err = New("{cdn}/{transform}.{ext}", "https://cdn.example.com", nil)
+	assert.Error(err, "template without {key} is invalid")
+	_, err = New("https://cdn.example.com/image.jpg", "", nil)
+	assert.Error(err, "template without placeholders is invalid")
+	_, err = New("{cdn}/{key}/{transform}", "https://cdn.example.com", nil)
+	assert.NoError(err)
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"sort"
+)
+
+// Compat - quirks of S3 compatible storage provider
+type Compat struct {
+	// PathStyle - bucket is addressed by path instead of subdomain
+	PathStyle bool
+	// StripXMLNS - namespace is removed from DeleteObjects body, some providers fail to parse it
+	StripXMLNS bool
+	// MultiDelete - DeleteObjects is supported, otherwise objects are deleted one by one
+	MultiDelete bool
+	// ACL - canned ACLs are supported, otherwise visibility of objects is controlled by bucket policy
+	ACL bool
+}
+
+// CompatProfiles - known providers, "vk" is default as VK Cloud Hotbox is used by default endpoint
+var CompatProfiles = map[string]Compat{
+	"aws":    {MultiDelete: true, ACL: true},
+	"vk":     {StripXMLNS: true, MultiDelete: true, ACL: true},
+	"hotbox": {StripXMLNS: true, MultiDelete: true, ACL: true},
+	"minio":  {PathStyle: true, MultiDelete: true},
+	"ceph":   {PathStyle: true, MultiDelete: true, ACL: true},
+	"gcs":    {ACL: true},
+}
+
+// CompatProfile - returns quirks of provider by name
+func CompatProfile(name string) (Compat, error) {
+	if compat, ok := CompatProfiles[name]; ok {
+		return compat, nil
+	}
+	var names = make([]string, 0, len(CompatProfiles))
+	for known := range CompatProfiles {
+		names = append(names, known)
+	}
+	sort.Strings(names)
+	return Compat{}, fmt.Errorf("unknown s3 compatibility profile %q, known profiles are %v", name, names)
+}
+
+var deleteXMLNS = []byte(` xmlns="http://s3.amazonaws.com/doc/2006-03-01/"`)
+
+// stripXMLNS - request handler removing namespace from DeleteObjects body
+func stripXMLNS(r *request.Request) {
+	if r.Operation.Name != "DeleteObjects" {
+		return
+	}
+	var buf = new(bytes.Buffer)
+	if _, err := buf.ReadFrom(r.Body); err == nil {
+		r.SetReaderBody(bytes.NewReader(bytes.Replace(buf.Bytes(), deleteXMLNS, []byte(""), -1)))
+	}
+}
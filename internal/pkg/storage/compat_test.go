@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeRequest struct {
+	Method string
+	Host   string
+	Path   string
+	Header http.Header
+	Body   string
+	Delete bool
+}
+
+// fakeS3 - local S3 compatible provider used as HTTP transport of SDK, it records received requests
+type fakeS3 struct {
+	mu          sync.Mutex
+	multiDelete bool
+	requests    []fakeRequest
+}
+
+func (f *fakeS3) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+	}
+	var _, multiDelete = r.URL.Query()["delete"]
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{
+		Method: r.Method,
+		Host:   r.URL.Host,
+		Path:   r.URL.Path,
+		Header: r.Header,
+		Body:   string(body),
+		Delete: multiDelete,
+	})
+	f.mu.Unlock()
+
+	var status, response = http.StatusOK, ""
+	switch {
+	case multiDelete && !f.multiDelete:
+		status, response = http.StatusNotImplemented, "<Error><Code>NotImplemented</Code><Message>not implemented</Message></Error>"
+	case multiDelete:
+		response = "<DeleteResult></DeleteResult>"
+	case r.Method == http.MethodDelete:
+		status = http.StatusNoContent
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Etag": []string{`"etag"`}},
+		Body:          ioutil.NopCloser(strings.NewReader(response)),
+		ContentLength: int64(len(response)),
+		Request:       r,
+	}, nil
+}
+
+func newFakeContext(t *testing.T, compat string, fake *fakeS3) *S3Context {
+	var ctx = &S3Context{config: &utils.Config{}, bucket: "bucket"}
+	var err error
+	ctx.compat, err = CompatProfile(compat)
+	assert.NoError(t, err)
+	ctx.session, err = newSession("http://s3.test", "us-east-1", "key", "secret", ctx.compat)
+	assert.NoError(t, err)
+	ctx.session.Config.HTTPClient = &http.Client{Transport: fake}
+	ctx.session.Config.MaxRetries = aws.Int(0)
+	return ctx
+}
+
+var compatObjects = []ObjectID{{Key: aws.String("key/a.jpg")}, {Key: aws.String("key/b.jpg")}}
+
+func TestCompatProfile(t *testing.T) {
+	var _, err = CompatProfile("vk")
+	assert.NoError(t, err)
+	_, err = CompatProfile("unknown")
+	assert.Error(t, err)
+}
+
+func TestCompatDeleteObjects(t *testing.T) {
+	assert := assert.New(t)
+
+	var fake = &fakeS3{multiDelete: true}
+	assert.NoError(newFakeContext(t, "vk", fake).DeleteFiles(compatObjects))
+	assert.Len(fake.requests, 1)
+	assert.True(fake.requests[0].Delete)
+	assert.Equal("bucket.s3.test", fake.requests[0].Host, "bucket should be addressed by subdomain")
+	assert.NotContains(fake.requests[0].Body, "xmlns", "namespace should be stripped")
+	assert.Contains(fake.requests[0].Body, "key/b.jpg")
+
+	fake = &fakeS3{multiDelete: true}
+	assert.NoError(newFakeContext(t, "aws", fake).DeleteFiles(compatObjects))
+	assert.Len(fake.requests, 1)
+	assert.Contains(fake.requests[0].Body, "xmlns", "namespace should be kept")
+
+	fake = &fakeS3{multiDelete: true}
+	assert.NoError(newFakeContext(t, "minio", fake).DeleteFiles(compatObjects))
+	assert.Equal("s3.test", fake.requests[0].Host)
+	assert.Equal("/bucket", fake.requests[0].Path, "bucket should be addressed by path")
+
+	fake = &fakeS3{multiDelete: true}
+	assert.NoError(newFakeContext(t, "gcs", fake).DeleteFiles(compatObjects))
+	assert.Len(fake.requests, 2, "objects should be deleted one by one")
+	assert.Equal(http.MethodDelete, fake.requests[0].Method)
+	assert.Equal("/key/a.jpg", fake.requests[0].Path)
+
+	fake = &fakeS3{multiDelete: false}
+	assert.NoError(newFakeContext(t, "aws", fake).DeleteFiles(compatObjects))
+	assert.Len(fake.requests, 3, "single deletes should be used if DeleteObjects is not implemented")
+	assert.True(fake.requests[0].Delete)
+	assert.Equal(http.MethodDelete, fake.requests[2].Method)
+
+	fake = &fakeS3{multiDelete: true}
+	assert.NoError(newFakeContext(t, "aws", fake).DeleteFiles(nil))
+	assert.Len(fake.requests, 0, "nothing should be sent without objects")
+}
+
+func TestCompatACL(t *testing.T) {
+	assert := assert.New(t)
+
+	var fake = &fakeS3{}
+	var _, err = newFakeContext(t, "aws", fake).UploadFileWithContext(context.Background(), bytes.NewReader([]byte("image")), "key/a.jpg",
+		ObjectOptions{ContentType: "image/jpeg", Private: true})
+	assert.NoError(err)
+	assert.Len(fake.requests, 1)
+	assert.Equal(s3.ObjectCannedACLPrivate, fake.requests[0].Header.Get("X-Amz-Acl"))
+
+	fake = &fakeS3{}
+	_, err = newFakeContext(t, "minio", fake).UploadFileWithContext(context.Background(), bytes.NewReader([]byte("image")), "key/a.jpg",
+		ObjectOptions{ContentType: "image/jpeg", Private: true})
+	assert.NoError(err)
+	assert.Len(fake.requests, 1)
+	assert.Equal("", fake.requests[0].Header.Get("X-Amz-Acl"), "acl should not be sent")
+
+	assert.True(newFakeContext(t, "aws", &fakeS3{}).SupportsACL())
+	assert.False(newFakeContext(t, "minio", &fakeS3{}).SupportsACL(), "private objects should not be stored without acl")
+}
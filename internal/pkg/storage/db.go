@@ -3,8 +3,12 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/imagemeta"
+	"github.com/KazanExpress/louis/internal/pkg/phash"
+	"github.com/KazanExpress/louis/internal/pkg/placeholder"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 	"strings"
 	// gorm dialects need to be included in that way
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -19,6 +23,15 @@ const (
 
 var ErrorNoRowsInResultSet = errors.New("sql: no rows in result set")
 
+// ErrVersionConflict - image was replaced or is being replaced by another request
+var ErrVersionConflict = errors.New("image is replaced by another request")
+
+// ErrUploadLocked - resumable upload is written by another request
+var ErrUploadLocked = errors.New("upload is locked by another request")
+
+// lockNotAvailable - postgres error code of NOWAIT lock which is held by another transaction
+const lockNotAvailable = "55P03"
+
 type DB struct {
 	*gorm.DB
 	driver string
@@ -50,14 +63,16 @@ func (db *DB) InitDB() error {
 
 	lock.Lock()
 	defer lock.Unlock()
-	d := db.AutoMigrate(&User{}, &Image{}, &Transformation{})
+	d := db.AutoMigrate(&User{}, &Image{}, &Transformation{}, &TagSettings{}, &ImportItem{}, &ResumableUpload{}, &DirectUpload{})
 	return d.Error
 
 }
 
+// EnsureTransformations - creates missing transformations, headers of existing ones are updated
 func (db *DB) EnsureTransformations(trans []Transformation) error {
 	for _, tr := range trans {
-		err := db.Set("gorm:insert_option", "ON CONFLICT (name) DO NOTHING").Create(&tr).Error
+		err := db.Set("gorm:insert_option", "ON CONFLICT (name) DO UPDATE SET "+
+			"cache_control = excluded.cache_control, content_disposition = excluded.content_disposition").Create(&tr).Error
 		if err != nil && err.Error() != ErrorNoRowsInResultSet.Error() {
 			return err
 		}
@@ -67,6 +82,35 @@ func (db *DB) EnsureTransformations(trans []Transformation) error {
 	// https://github.com/jinzhu/gorm/issues/721
 }
 
+// EnsureTagSettings - creates or updates settings of given tags
+func (db *DB) EnsureTagSettings(settings []TagSettings) error {
+	for _, ts := range settings {
+		if err := ts.Validate(); err != nil {
+			return err
+		}
+		var existing TagSettings
+		var err = db.Where("Tag = ?", ts.Tag).First(&existing).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		// Save writes zero values as well, so removed limits are reset
+		ts.ID = existing.ID
+		if err = db.Save(&ts).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTagSettings - returns settings of given tags, tags without settings are skipped
+func (db *DB) GetTagSettings(tags []string) ([]TagSettings, error) {
+	var settings []TagSettings
+	if len(tags) == 0 {
+		return settings, nil
+	}
+	return settings, db.Where("Tag IN (?)", tags).Find(&settings).Error
+}
+
 func (db *DB) DropDB() error {
 
 	lock.Lock()
@@ -80,6 +124,22 @@ func (db *DB) DropDB() error {
 		if err != nil {
 			log.Printf("ERROR: on droping db - %v", err)
 		}
+		err = db.DropTableIfExists(&TagSettings{}).Error
+		if err != nil {
+			log.Printf("ERROR: on droping db - %v", err)
+		}
+		err = db.DropTableIfExists(&ImportItem{}).Error
+		if err != nil {
+			log.Printf("ERROR: on droping db - %v", err)
+		}
+		err = db.DropTableIfExists(&ResumableUpload{}).Error
+		if err != nil {
+			log.Printf("ERROR: on droping db - %v", err)
+		}
+		err = db.DropTableIfExists(&DirectUpload{}).Error
+		if err != nil {
+			log.Printf("ERROR: on droping db - %v", err)
+		}
 		err = db.DropTableIfExists(&User{}).Error
 		if err != nil {
 			log.Printf("ERROR: on droping db - %v", err)
@@ -200,6 +260,268 @@ func (db *DB) SetImageURL(key string, userID int32, URL string) error {
 	return err
 }
 
+func (db *DB) SetImagePlaceholder(imageKey string, p *placeholder.Placeholder) error {
+	return db.Update(imageKey, map[string]interface{}{
+		"Blur_Hash":      p.BlurHash,
+		"Dominant_Color": p.DominantColor,
+		"Preview":        p.Preview,
+	})
+}
+
+func (db *DB) SetImageMetadata(imageKey string, m *imagemeta.Metadata) error {
+	return db.Update(imageKey, map[string]interface{}{"Metadata": m})
+}
+
+func (db *DB) SetImageFrames(imageKey string, frames int, animated bool) error {
+	return db.Update(imageKey, map[string]interface{}{"Frames": frames, "Animated": animated})
+}
+
+func (db *DB) SetImagePrivate(imageKey string, private bool) error {
+	return db.Update(imageKey, map[string]interface{}{"Private": private})
+}
+
+func (db *DB) SetImageFolder(imageKey, folder string) error {
+	return db.Update(imageKey, map[string]interface{}{"Folder": folder})
+}
+
+// SetImageStorage - saves storage profile and storage class of "real" copy chosen on first upload
+func (db *DB) SetImageStorage(imageKey, profile, realStorageClass string) error {
+	return db.Update(imageKey, map[string]interface{}{"Storage": profile, "Real_Storage_Class": realStorageClass})
+}
+
+func (db *DB) SetImageReplicaStatus(imageKey, status string) error {
+	return db.Update(imageKey, map[string]interface{}{"Replica_Status": status})
+}
+
+func (db *DB) SetImageRealEncryptionKey(imageKey, keyID string) error {
+	return db.Update(imageKey, map[string]interface{}{"Real_Encryption_Key": keyID})
+}
+
+// SetImageReplicated - marks replica of image as done and saves id of key it is encrypted with
+func (db *DB) SetImageReplicated(imageKey, keyID string) error {
+	return db.Update(imageKey, map[string]interface{}{"Replica_Status": ReplicaDone, "Replica_Encryption_Key": keyID})
+}
+
+func (db *DB) SetImageParent(imageKey, parentKey string) error {
+	return db.Update(imageKey, map[string]interface{}{"Parent_Key": parentKey})
+}
+
+// ReserveImageVersion - reserves next version of image for replacement if image is still of given version
+// and it is not being replaced, reservation made before expired is taken over; version of image is not changed,
+// so image is served from current version until CommitImageVersion, ErrVersionConflict is returned if reservation fails
+func (db *DB) ReserveImageVersion(imageKey string, version int, expired time.Time) error {
+	var result = db.Model(&Image{}).
+		Where("Key = ? AND Version = ? AND (Replacing_Version = 0 OR Replace_Date < ?)", imageKey, version, expired).
+		UpdateColumns(map[string]interface{}{"Replacing_Version": version + 1, "Replace_Date": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// CommitImageVersion - moves image to version reserved by ReserveImageVersion once its objects are uploaded,
+// ErrVersionConflict is returned if reservation was taken over
+func (db *DB) CommitImageVersion(imageKey string, version int, URL string) error {
+	var result = db.Model(&Image{}).
+		Where("Key = ? AND Version = ? AND Replacing_Version = ?", imageKey, version, version+1).
+		UpdateColumns(map[string]interface{}{"Version": version + 1, "Url": URL, "Replacing_Version": 0})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// ReleaseImageVersion - cancels reservation made by ReserveImageVersion if replacement failed
+func (db *DB) ReleaseImageVersion(imageKey string, version int) error {
+	return db.Model(&Image{}).
+		Where("Key = ? AND Version = ? AND Replacing_Version = ?", imageKey, version, version+1).
+		UpdateColumn("Replacing_Version", 0).Error
+}
+
+func (db *DB) SetImageContentHash(imageKey, hash string) error {
+	return db.Update(imageKey, map[string]interface{}{"Content_Hash": hash})
+}
+
+// FindImageByContentHash - returns claimed and not archived image of user with the same content and tags
+func (db *DB) FindImageByContentHash(hash string, userID int32, tags []string) (*Image, error) {
+	var images []Image
+	var err = db.Where("Content_Hash = ? AND User_ID = ? AND Approved = ? AND Deleted = ? AND Transforms_Uploaded = ?", hash, userID, true, false, true).
+		Order("id").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range images {
+		if sameTags(images[i].Tags, tags) {
+			return &images[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// sameTags - reports whether lists contain the same tags in any order
+func sameTags(a, b []string) bool {
+	var set = make(map[string]bool, len(a))
+	for _, tag := range a {
+		set[tag] = true
+	}
+	var other = make(map[string]bool, len(b))
+	for _, tag := range b {
+		if !set[tag] {
+			return false
+		}
+		other[tag] = true
+	}
+	return len(set) == len(other)
+}
+
+func (db *DB) SetImagePerceptualHash(imageKey string, hash uint64) error {
+	return db.Update(imageKey, map[string]interface{}{
+		"Perceptual_Hash":       int64(hash),
+		"Perceptual_Hash_Band0": phash.Band(hash, 0),
+		"Perceptual_Hash_Band1": phash.Band(hash, 1),
+		"Perceptual_Hash_Band2": phash.Band(hash, 2),
+		"Perceptual_Hash_Band3": phash.Band(hash, 3),
+	})
+}
+
+// hammingDistanceSQL - number of different bits of perceptual hash and parameter
+const hammingDistanceSQL = "length(replace((Perceptual_Hash # ?)::bit(64)::text, '0', ''))"
+
+// FindSimilarImages - returns not archived images which perceptual hash differs from given one
+// in at most maxDistance bits, closest first; for small distances candidates are found by indexed bands
+func (db *DB) FindSimilarImages(hash uint64, maxDistance, limit int) ([]SimilarImage, error) {
+	var query = db.Model(&Image{}).
+		Where("Perceptual_Hash <> 0 AND Deleted = ?", false).
+		Where(hammingDistanceSQL+" <= ?", int64(hash), maxDistance)
+	if maxDistance < phash.Bands {
+		query = query.Where("Perceptual_Hash_Band0 = ? OR Perceptual_Hash_Band1 = ? OR Perceptual_Hash_Band2 = ? OR Perceptual_Hash_Band3 = ?",
+			phash.Band(hash, 0), phash.Band(hash, 1), phash.Band(hash, 2), phash.Band(hash, 3))
+	}
+
+	var images []Image
+	var err = query.Order(gorm.Expr(hammingDistanceSQL, int64(hash))).Order("id").Limit(limit).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	var similar = make([]SimilarImage, len(images))
+	for i := range images {
+		similar[i] = SimilarImage{Image: images[i], Distance: phash.Distance(hash, uint64(images[i].PerceptualHash))}
+	}
+	return similar, nil
+}
+
+// CreateImportItems - creates all items of import job or none of them
+func (db *DB) CreateImportItems(items []ImportItem) error {
+	var tx = db.Begin()
+	for i := range items {
+		if err := tx.Create(&items[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+func (db *DB) GetImportItem(id int64) (*ImportItem, error) {
+	var item = new(ImportItem)
+	return item, db.First(item, id).Error
+}
+
+// GetImportJob - returns items of import job in order they were requested
+func (db *DB) GetImportJob(jobID string, userID int32) ([]ImportItem, error) {
+	var items []ImportItem
+	return items, db.Where("Job_ID = ? AND User_ID = ?", jobID, userID).Order("id").Find(&items).Error
+}
+
+// SetImportItemStatus - sets status of import item, code and message are set for failed items
+func (db *DB) SetImportItemStatus(id int64, status, code, message string) error {
+	return db.Model(&ImportItem{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"Status": status, "Code": code, "Error": message, "Update_Date": time.Now()}).Error
+}
+
+func (db *DB) CreateResumableUpload(upload *ResumableUpload) error {
+	return db.Create(upload).Error
+}
+
+func (db *DB) GetResumableUpload(id string) (*ResumableUpload, error) {
+	var upload = new(ResumableUpload)
+	return upload, db.Where("id = ?", id).First(upload).Error
+}
+
+// LockResumableUpload - starts transaction which holds lock of upload row until it is committed or rolled back,
+// ErrUploadLocked is returned if upload is locked by another transaction
+func (db *DB) LockResumableUpload(id string) (*DB, *ResumableUpload, error) {
+	var tx = db.Begin()
+	var upload = new(ResumableUpload)
+	var err = tx.Set("gorm:query_option", "FOR UPDATE NOWAIT").Where("id = ?", id).First(upload).Error
+	if err != nil {
+		tx.Rollback()
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == lockNotAvailable {
+			return nil, nil, ErrUploadLocked
+		}
+		return nil, nil, err
+	}
+	return &DB{tx, db.driver}, upload, nil
+}
+
+func (db *DB) SetResumableUploadOffset(id string, offset int64) error {
+	return db.Model(&ResumableUpload{}).Where("id = ?", id).Updates(map[string]interface{}{"Offset": offset}).Error
+}
+
+// SetResumableUploadStatus - sets result of processing of completed upload
+func (db *DB) SetResumableUploadStatus(id, status, imageKey, code, message string) error {
+	return db.Model(&ResumableUpload{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"Status": status, "Image_Key": imageKey, "Code": code, "Error": message}).Error
+}
+
+func (db *DB) DeleteResumableUpload(id string) error {
+	return db.Where("id = ?", id).Delete(&ResumableUpload{}).Error
+}
+
+func (db *DB) CreateDirectUpload(upload *DirectUpload) error {
+	return db.Create(upload).Error
+}
+
+func (db *DB) GetDirectUpload(id string) (*DirectUpload, error) {
+	var upload = new(DirectUpload)
+	return upload, db.Where("id = ?", id).First(upload).Error
+}
+
+// StartDirectUpload - moves pending direct upload to processing, so only one request completes it,
+// ErrUploadLocked is returned if upload is not pending anymore
+func (db *DB) StartDirectUpload(id string) error {
+	var result = db.Model(&DirectUpload{}).
+		Where("id = ? AND status = ?", id, UploadPending).
+		UpdateColumn("Status", UploadProcessing)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadLocked
+	}
+	return nil
+}
+
+// SetDirectUploadStatus - sets result of processing of staged image
+func (db *DB) SetDirectUploadStatus(id, status, code, message string) error {
+	return db.Model(&DirectUpload{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"Status": status, "Code": code, "Error": message}).Error
+}
+
+func (db *DB) DeleteDirectUpload(id string) error {
+	return db.Where("id = ?", id).Delete(&DirectUpload{}).Error
+}
+
 func (db *DB) SetImageTags(imageKey string, newTags []string) error {
 	var img = &Image{}
 	var err = db.Model(img).
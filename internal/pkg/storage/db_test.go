@@ -274,3 +274,82 @@ func (s *Suite) TestClaimImages() {
 		s.True(img.Approved)
 	}
 }
+
+func (s *Suite) TestFindImageByContentHash() {
+	var db = s.db
+	assert := assert.New(s.T())
+
+	imgID, err := db.AddImage("first", 1, "shop", "product")
+	assert.NoError(err)
+	assert.NoError(db.SetImageContentHash("first", "hash"))
+	assert.NoError(db.SetClaimImage("first", 1))
+	assert.NoError(db.SetTransformsUploaded(imgID))
+
+	img, err := db.FindImageByContentHash("hash", 1, []string{"product", "shop"})
+	assert.NoError(err)
+	assert.Equal("first", img.Key)
+
+	_, err = db.FindImageByContentHash("hash", 1, []string{"product"})
+	assert.Error(err)
+	_, err = db.FindImageByContentHash("hash", 2, []string{"product", "shop"})
+	assert.Error(err)
+	_, err = db.FindImageByContentHash("other", 1, []string{"product", "shop"})
+	assert.Error(err)
+}
+
+func (s *Suite) TestFindSimilarImages() {
+	var db = s.db
+	assert := assert.New(s.T())
+
+	var hashes = map[string]uint64{
+		"same":  0xf0f0f0f0f0f0f0f0,
+		"close": 0xf0f0f0f0f0f0f0f3,
+		"far":   0x0f0f0f0f0f0f0f0f,
+	}
+	for key, hash := range hashes {
+		_, err := db.AddImage(key, 1)
+		assert.NoError(err)
+		assert.NoError(db.SetImagePerceptualHash(key, hash))
+	}
+
+	images, err := db.FindSimilarImages(hashes["same"], 3, 10)
+	assert.NoError(err)
+	assert.Len(images, 2)
+	assert.Equal("same", images[0].Key)
+	assert.Equal(0, images[0].Distance)
+	assert.Equal("close", images[1].Key)
+	assert.Equal(2, images[1].Distance)
+
+	images, err = db.FindSimilarImages(hashes["same"], 64, 1)
+	assert.NoError(err)
+	assert.Len(images, 1)
+
+	assert.NoError(db.DeleteImage("same"))
+	images, err = db.FindSimilarImages(hashes["same"], 3, 10)
+	assert.NoError(err)
+	assert.Len(images, 1)
+}
+
+func (s *Suite) TestImportItems() {
+	var db = s.db
+	assert := assert.New(s.T())
+
+	var items = []ImportItem{
+		{JobID: "job", UserID: 1, URL: "http://localhost/1.jpg", Key: "first", Tags: []string{"shop"}},
+		{JobID: "job", UserID: 1, URL: "http://localhost/2.jpg", Key: "second"},
+	}
+	assert.NoError(db.CreateImportItems(items))
+	assert.NoError(db.SetImportItemStatus(items[1].ID, ImportFailed, "fetch_failed", "not found"))
+
+	job, err := db.GetImportJob("job", 1)
+	assert.NoError(err)
+	assert.Len(job, 2)
+	assert.Equal("first", job[0].Key)
+	assert.Equal(ImportPending, job[0].Status)
+	assert.Equal(ImportFailed, job[1].Status)
+	assert.Equal("fetch_failed", job[1].Code)
+
+	job, err = db.GetImportJob("job", 2)
+	assert.NoError(err)
+	assert.Empty(job)
+}
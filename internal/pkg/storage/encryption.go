@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strings"
+)
+
+// Server side encryption modes
+const (
+	// SSENone - objects are stored as is or encrypted by bucket default settings
+	SSENone = ""
+	// SSES3 - objects are encrypted by keys managed by S3
+	SSES3 = "s3"
+	// SSEKMS - objects are encrypted by KMS key, default key of account is used if key id is empty
+	SSEKMS = "kms"
+	// SSECustomer - objects are encrypted by key sent with every request, it is never stored by S3
+	SSECustomer = "customer"
+)
+
+// SSEModes - allowed values of SSE setting
+var SSEModes = []string{SSENone, SSES3, SSEKMS, SSECustomer}
+
+// Encryption - server side encryption of objects, nil value means no encryption
+type Encryption struct {
+	Mode     string
+	KMSKeyID string
+	// customerKey - raw 256 bit key of SSE-C, SDK encodes it and calculates its MD5
+	customerKey string
+}
+
+// NewEncryption - creates encryption of given mode, customer key is base64 encoded 256 bit key,
+// nil is returned for SSENone
+func NewEncryption(mode, kmsKeyID, customerKey string) (*Encryption, error) {
+	switch mode {
+	case SSENone:
+		return nil, nil
+	case SSES3:
+		return &Encryption{Mode: mode}, nil
+	case SSEKMS:
+		return &Encryption{Mode: mode, KMSKeyID: kmsKeyID}, nil
+	case SSECustomer:
+		var key, err = base64.StdEncoding.DecodeString(customerKey)
+		if err != nil {
+			return nil, fmt.Errorf("customer key should be base64 encoded: %v", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("customer key should be 256 bit long, got %v bits", len(key)*8)
+		}
+		return &Encryption{Mode: mode, customerKey: string(key)}, nil
+	}
+	return nil, fmt.Errorf("unknown encryption mode %q", mode)
+}
+
+// KeyID - identifies key objects are encrypted with, customer keys are identified by MD5 like S3 does,
+// empty value means no encryption
+func (e *Encryption) KeyID() string {
+	if e == nil {
+		return ""
+	}
+	switch e.Mode {
+	case SSEKMS:
+		return SSEKMS + ":" + e.KMSKeyID
+	case SSECustomer:
+		var sum = md5.Sum([]byte(e.customerKey))
+		return SSECustomer + ":" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	return e.Mode
+}
+
+// serverSideEncryption - returns x-amz-server-side-encryption and KMS key id headers of S3 and KMS modes
+func (e *Encryption) serverSideEncryption() (*string, *string) {
+	if e == nil {
+		return nil, nil
+	}
+	switch e.Mode {
+	case SSES3:
+		return aws.String(s3.ServerSideEncryptionAes256), nil
+	case SSEKMS:
+		if e.KMSKeyID == "" {
+			return aws.String(s3.ServerSideEncryptionAwsKms), nil
+		}
+		return aws.String(s3.ServerSideEncryptionAwsKms), aws.String(e.KMSKeyID)
+	}
+	return nil, nil
+}
+
+// customer - returns algorithm and key headers of SSE-C mode
+func (e *Encryption) customer() (*string, *string) {
+	if e == nil || e.Mode != SSECustomer {
+		return nil, nil
+	}
+	return aws.String("AES256"), aws.String(e.customerKey)
+}
+
+// Keyring - encryption of new objects and previous customer keys existing objects can be encrypted with
+type Keyring struct {
+	current *Encryption
+	// allVariants - all transformations are encrypted, otherwise only "real" copy is
+	allVariants bool
+	previous    map[string]*Encryption
+}
+
+// NewKeyring - reads SSE settings, nil is returned if encryption is disabled and there are no previous keys
+func NewKeyring(cfg *utils.Config) (*Keyring, error) {
+	var current, err = NewEncryption(cfg.SSE, cfg.SSEKMSKeyID, cfg.SSECustomerKey)
+	if err != nil {
+		return nil, err
+	}
+	// S3 refuses anonymous reads of SSE-C and SSE-KMS objects
+	if current != nil && (current.Mode == SSECustomer || current.Mode == SSEKMS) && cfg.SSEAllVariants {
+		return nil, fmt.Errorf("%v encryption can not be applied to all variants, they could not be read by public URLs", current.Mode)
+	}
+	if current == nil && len(cfg.SSEPreviousCustomerKeys) == 0 {
+		return nil, nil
+	}
+	var keyring = &Keyring{
+		current:     current,
+		allVariants: cfg.SSEAllVariants,
+		previous:    make(map[string]*Encryption, len(cfg.SSEPreviousCustomerKeys)),
+	}
+	for i, key := range cfg.SSEPreviousCustomerKeys {
+		var previous, err = NewEncryption(SSECustomer, "", key)
+		if err != nil {
+			return nil, fmt.Errorf("previous customer key #%v: %v", i+1, err)
+		}
+		keyring.previous[previous.KeyID()] = previous
+	}
+	return keyring, nil
+}
+
+// Current - returns encryption of new objects of transformation, "real" copy is always encrypted
+func (k *Keyring) Current(real bool) *Encryption {
+	if k == nil || !real && !k.allVariants {
+		return nil
+	}
+	return k.current
+}
+
+// CurrentKeyID - returns id of key new "real" copies are encrypted with
+func (k *Keyring) CurrentKeyID() string {
+	return k.Current(true).KeyID()
+}
+
+// Read - returns encryption which should be sent to read object encrypted with key of keyID,
+// only customer keys are sent, S3 decrypts other objects by itself
+func (k *Keyring) Read(keyID string) (*Encryption, error) {
+	if !strings.HasPrefix(keyID, SSECustomer+":") {
+		return nil, nil
+	}
+	if current := k.Current(true); current.KeyID() == keyID {
+		return current, nil
+	}
+	if k != nil {
+		if previous, ok := k.previous[keyID]; ok {
+			return previous, nil
+		}
+	}
+	return nil, fmt.Errorf("customer key %v is not configured", keyID)
+}
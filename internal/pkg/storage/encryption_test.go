@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"encoding/base64"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestNewEncryption(t *testing.T) {
+	assert := assert.New(t)
+
+	var none, err = NewEncryption(SSENone, "", "")
+	assert.NoError(err)
+	assert.Nil(none)
+	assert.Equal("", none.KeyID(), "nil encryption should have empty key id")
+
+	kms, err := NewEncryption(SSEKMS, "alias/louis", "")
+	assert.NoError(err)
+	assert.Equal("kms:alias/louis", kms.KeyID())
+
+	_, err = NewEncryption(SSECustomer, "", base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(err, "customer key should be 256 bit long")
+	_, err = NewEncryption("aes", "", "")
+	assert.Error(err)
+}
+
+func TestKeyring(t *testing.T) {
+	assert := assert.New(t)
+
+	var oldKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	var newKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", 32)))
+
+	keyring, err := NewKeyring(&utils.Config{})
+	assert.NoError(err)
+	assert.Nil(keyring, "keyring should be nil if encryption is disabled")
+	assert.Nil(keyring.Current(true))
+
+	keyring, err = NewKeyring(&utils.Config{SSE: SSECustomer, SSECustomerKey: newKey, SSEPreviousCustomerKeys: []string{oldKey}})
+	assert.NoError(err)
+	assert.NotNil(keyring.Current(true))
+	assert.Nil(keyring.Current(false), "only real copy should be encrypted by default")
+
+	old, _ := NewEncryption(SSECustomer, "", oldKey)
+	read, err := keyring.Read(old.KeyID())
+	assert.NoError(err)
+	assert.Equal(old.KeyID(), read.KeyID(), "previous key should be used to read old objects")
+
+	read, err = keyring.Read(keyring.CurrentKeyID())
+	assert.NoError(err)
+	assert.Equal(keyring.CurrentKeyID(), read.KeyID())
+
+	read, err = keyring.Read("kms:alias/louis")
+	assert.NoError(err)
+	assert.Nil(read, "key should not be sent to read kms encrypted object")
+
+	_, err = keyring.Read("customer:unknown")
+	assert.Error(err)
+
+	_, err = NewKeyring(&utils.Config{SSE: SSECustomer, SSECustomerKey: newKey, SSEAllVariants: true})
+	assert.Error(err, "customer key should not be applied to public variants")
+	_, err = NewKeyring(&utils.Config{SSE: SSEKMS, SSEAllVariants: true})
+	assert.Error(err, "kms key should not be applied to public variants")
+}
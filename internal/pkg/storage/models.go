@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"github.com/KazanExpress/louis/internal/pkg/imagemeta"
 	"github.com/lib/pq"
 	"time"
 )
@@ -23,6 +24,52 @@ type Image struct {
 	AppliedTags          pq.StringArray `gorm:"type:varchar(256)[]"`
 	Progressive          bool           `gorm:"default:false"`
 	WithRealCopy         bool           // if "real" transform is applied
+	BlurHash             string         `gorm:"default:''"`
+	DominantColor        string         `gorm:"default:''"`
+	Preview              string         `gorm:"type:text;default:''"` // tiny jpeg as data URI
+	// Metadata - EXIF and IPTC fields of uploaded image
+	Metadata *imagemeta.Metadata `gorm:"type:jsonb"`
+	Frames   int                 `gorm:"default:1"`
+	// Animated - if animation of image is kept by transformations
+	Animated bool `gorm:"default:false"`
+	// ParentKey - key of image which this one is derived from
+	ParentKey string `gorm:"default:''"`
+	// Version - number of replacements of image, objects of version N > 0 are stored under "<key>/v<N>/"
+	Version int `gorm:"default:0"`
+	// ReplacingVersion - version which is being uploaded by replacement, 0 if there is none,
+	// reservation made at ReplaceDate is taken over by another replacement if it is too old
+	ReplacingVersion int       `gorm:"default:0"`
+	ReplaceDate      time.Time `gorm:"default:now()"`
+	// ContentHash - hex encoded sha256 of uploaded file and crop points
+	ContentHash string `gorm:"index;default:''"`
+	// PerceptualHash - dHash of image stored as signed number, 0 means it is not calculated,
+	// bands are 16 bit parts of hash indexed to find images with small hamming distance
+	PerceptualHash      int64 `gorm:"default:0"`
+	PerceptualHashBand0 int   `gorm:"index;default:0"`
+	PerceptualHashBand1 int   `gorm:"index;default:0"`
+	PerceptualHashBand2 int   `gorm:"index;default:0"`
+	PerceptualHashBand3 int   `gorm:"index;default:0"`
+	// Private - objects of image are uploaded without public ACL, it is set on upload by tag settings
+	Private bool `gorm:"default:false"`
+	// Folder - folder of image objects chosen by key layout on first upload,
+	// it is empty for images uploaded before layouts, they are stored right under key
+	Folder string `gorm:"default:''"`
+	// Storage - storage profile objects are stored in, empty value means S3_BUCKET,
+	// RealStorageClass - storage class of "real" copy, both are chosen on first upload by tag settings
+	Storage          string `gorm:"default:''"`
+	RealStorageClass string `gorm:"default:''"`
+	// ReplicaStatus - state of copying "real" object to replica store, empty if replication is disabled
+	ReplicaStatus string `gorm:"index;default:''"`
+	// RealEncryptionKey - id of key "real" copy is encrypted with, see Encryption.KeyID
+	RealEncryptionKey string `gorm:"index;default:''"`
+	// ReplicaEncryptionKey - id of key replica of "real" copy is encrypted with
+	ReplicaEncryptionKey string `gorm:"index;default:''"`
+}
+
+// SimilarImage - image found by perceptual hash
+type SimilarImage struct {
+	Image
+	Distance int
 }
 
 // Transformation - is model of how transforamiotn stored in DB
@@ -35,10 +82,95 @@ type Transformation struct {
 	Quality int    `json:"quality"`
 	Width   int    `json:"width"`
 	Height  int    `json:"height"`
+	// Operations - steps of "chain" transformation, ignored by other types
+	Operations Operations `json:"operations,omitempty" gorm:"type:jsonb"`
+	// Set, Density and Sizes are filled for transformations generated from TransformationSet
+	Set     string `json:"-" gorm:"default:''"`
+	Density int    `json:"-" gorm:"default:0"`
+	Sizes   string `json:"-" gorm:"default:''"`
+	// KeepCopyright and KeepICC - write artist, copyright and color profile of source into output jpeg
+	KeepCopyright bool `json:"keepCopyright" gorm:"default:false"`
+	KeepICC       bool `json:"keepIcc" gorm:"default:false"`
+	// KeepAnimation - resize animated image keeping all frames, AnimationFormat is "webp" (default) or "gif"
+	KeepAnimation   bool   `json:"keepAnimation" gorm:"default:false"`
+	AnimationFormat string `json:"animationFormat,omitempty" gorm:"default:''"`
+	// CacheControl and ContentDisposition - headers of uploaded objects, CACHE_CONTROL is used if CacheControl is empty,
+	// "{key}", "{transform}" and "{ext}" of ContentDisposition are replaced by image key, transformation name and extension
+	CacheControl       string `json:"cacheControl,omitempty" gorm:"default:''"`
+	ContentDisposition string `json:"contentDisposition,omitempty" gorm:"default:''"`
+}
+
+// TransformationSet - describes responsive variants of one transformation,
+// each density produces transformation named "<name>_<density>x"
+// with width and height multiplied by density
+type TransformationSet struct {
+	Name       string     `json:"name"`
+	Tag        string     `json:"tag"`
+	Type       string     `json:"type"`
+	Quality    int        `json:"quality"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	Operations Operations `json:"operations,omitempty"`
+	Densities  []int      `json:"densities"`
+	// Sizes - value for html "sizes" attribute returned along with srcset
+	Sizes         string `json:"sizes"`
+	KeepCopyright bool   `json:"keepCopyright"`
+	KeepICC       bool   `json:"keepIcc"`
+
+	KeepAnimation   bool   `json:"keepAnimation"`
+	AnimationFormat string `json:"animationFormat,omitempty"`
+
+	CacheControl       string `json:"cacheControl,omitempty"`
+	ContentDisposition string `json:"contentDisposition,omitempty"`
+}
+
+// Dedup modes of tag settings
+const (
+	// DedupReuse - existing image key is returned
+	DedupReuse = "reuse"
+	// DedupCopy - new image is created from copies of existing image objects
+	DedupCopy = "copy"
+)
+
+// DedupModes - allowed values of TagSettings.Dedup, empty value disables deduplication
+var DedupModes = []string{"", DedupReuse, DedupCopy}
+
+// TagSettings - upload restrictions of images with tag,
+// zero value of limit means that only global limit is applied
+type TagSettings struct {
+	ID        int32  `json:"-"`
+	Tag       string `json:"tag" gorm:"unique"`
+	MaxPixels int64  `json:"maxPixels"`
+	MaxWidth  int    `json:"maxWidth"`
+	MaxHeight int    `json:"maxHeight"`
+	MinWidth  int    `json:"minWidth"`
+	MinHeight int    `json:"minHeight"`
+	// MinAspect and MaxAspect - range of width to height ratio in "width:height" format
+	MinAspect   string `json:"minAspect" gorm:"default:''"`
+	MaxAspect   string `json:"maxAspect" gorm:"default:''"`
+	MaxFileSize int64  `json:"maxFileSize"`
+	// Formats - allowed input formats, empty list allows all supported formats
+	Formats pq.StringArray `json:"formats" gorm:"type:varchar(16)[]"`
+	// MaxTags - maximum number of tags of image with this tag
+	MaxTags int `json:"maxTags"`
+	// RequiredTags - tags which should be uploaded along with this tag
+	RequiredTags pq.StringArray `json:"requiredTags" gorm:"type:varchar(256)[]"`
+	// AllowAnimation - keep animation of gif and webp images, otherwise only first frame is used
+	AllowAnimation bool `json:"allowAnimation"`
+	// Dedup - what to do with upload identical to existing image with the same tags, see DedupModes
+	Dedup string `json:"dedup,omitempty" gorm:"default:''"`
+	// Private - objects of image are not public, API returns presigned URLs of them
+	Private bool `json:"private"`
+	// Storage - storage profile of image objects, one of S3_PROFILES
+	Storage string `json:"storage,omitempty" gorm:"default:''"`
+	// RealStorageClass - storage class of "real" copy, e.g. STANDARD_IA, see StorageClasses
+	RealStorageClass string `json:"realStorageClass,omitempty" gorm:"default:''"`
 }
 
 type TransformList struct {
-	Transformations []Transformation `json:"transformations"`
+	Transformations []Transformation    `json:"transformations"`
+	Sets            []TransformationSet `json:"sets"`
+	Tags            []TagSettings       `json:"tags"`
 }
 
 type User struct {
@@ -46,3 +178,76 @@ type User struct {
 	PublicKey string
 	SecretKey string
 }
+
+// Import item statuses
+const (
+	ImportPending = "pending"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// ImportItem - image which is downloaded from remote URL by background worker,
+// items created by one request have the same JobID
+type ImportItem struct {
+	ID         int64          `json:"-"`
+	JobID      string         `json:"-" gorm:"index"`
+	UserID     int32          `json:"-"`
+	URL        string         `json:"url" gorm:"type:text"`
+	Key        string         `json:"key"`
+	Tags       pq.StringArray `json:"tags" gorm:"type:varchar(256)[]"`
+	Status     string         `json:"status" gorm:"default:'pending'"`
+	Error      string         `json:"error,omitempty" gorm:"type:text;default:''"`
+	Code       string         `json:"code,omitempty" gorm:"default:''"`
+	CreateDate time.Time      `json:"createDate" gorm:"default:now()"`
+	UpdateDate time.Time      `json:"updateDate" gorm:"default:now()"`
+}
+
+// Statuses of replication of "real" copy
+const (
+	ReplicaPending = "pending"
+	ReplicaDone    = "done"
+	ReplicaFailed  = "failed"
+)
+
+// Statuses of resumable and direct uploads
+const (
+	UploadPending = "pending"
+	// UploadProcessing - direct upload is being completed by request
+	UploadProcessing = "processing"
+	UploadDone       = "done"
+	UploadFailed     = "failed"
+)
+
+// ResumableUpload - state of upload received by chunks, received chunks are kept as staged objects
+type ResumableUpload struct {
+	ID     string `gorm:"primary_key"`
+	UserID int32
+	Length int64
+	Offset int64 `gorm:"default:0"`
+	// Metadata - raw value of tus Upload-Metadata header
+	Metadata string `gorm:"type:text;default:''"`
+	// Claim - if image should be claimed, upload is created with secret key
+	Claim      bool
+	Status     string    `gorm:"default:'pending'"`
+	ImageKey   string    `gorm:"default:''"`
+	Error      string    `gorm:"type:text;default:''"`
+	Code       string    `gorm:"default:''"`
+	CreateDate time.Time `gorm:"default:now()"`
+	ExpireDate time.Time
+}
+
+// DirectUpload - image uploaded by client right to staging object of bucket with presigned URL,
+// it is processed when client completes upload
+type DirectUpload struct {
+	ID         string `gorm:"primary_key"`
+	UserID     int32
+	ImageKey   string
+	Tags       pq.StringArray `gorm:"type:varchar(256)[]"`
+	CropPoints string         `gorm:"default:''"`
+	Claim      bool
+	Status     string    `gorm:"default:'pending'"`
+	Error      string    `gorm:"type:text;default:''"`
+	Code       string    `gorm:"default:''"`
+	CreateDate time.Time `gorm:"default:now()"`
+	ExpireDate time.Time
+}
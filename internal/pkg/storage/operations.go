@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChainTransformType - type of transformation which applies its Operations one by one
+const ChainTransformType = "chain"
+
+// Operation types allowed in transformation chain
+const (
+	OpResize    = "resize"
+	OpCrop      = "crop"
+	OpRotate    = "rotate"
+	OpFlip      = "flip"
+	OpBlur      = "blur"
+	OpSharpen   = "sharpen"
+	OpGrayscale = "grayscale"
+	OpFormat    = "format"
+	OpQuality   = "quality"
+)
+
+// Resize modes
+const (
+	ResizeFit   = "fit"
+	ResizeFill  = "fill"
+	ResizeForce = "force"
+)
+
+var (
+	// Gravities - allowed values of crop and fill gravity
+	Gravities = []string{"", "centre", "north", "east", "south", "west"}
+	// Formats - allowed output formats
+	Formats = []string{"jpeg", "png", "webp"}
+	// AnimationFormats - allowed output formats of animated images
+	AnimationFormats = []string{"", "webp", "gif"}
+	// AnimatedTransformTypes - types of transformations which can keep animation
+	AnimatedTransformTypes = []string{"fit", "fill"}
+)
+
+// Operation - single step of chained transformation
+type Operation struct {
+	Op        string  `json:"op"`
+	Mode      string  `json:"mode,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	X         int     `json:"x,omitempty"`
+	Y         int     `json:"y,omitempty"`
+	Aspect    string  `json:"aspect,omitempty"`
+	Gravity   string  `json:"gravity,omitempty"`
+	Angle     int     `json:"angle,omitempty"`
+	Direction string  `json:"direction,omitempty"`
+	Sigma     float64 `json:"sigma,omitempty"`
+	Radius    int     `json:"radius,omitempty"`
+	Format    string  `json:"format,omitempty"`
+	Quality   int     `json:"quality,omitempty"`
+}
+
+// Operations - list of operations stored as json
+type Operations []Operation
+
+// Value - implements driver.Valuer
+func (ops Operations) Value() (driver.Value, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(ops)
+}
+
+// Scan - implements sql.Scanner
+func (ops *Operations) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case nil:
+		*ops = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, ops)
+	case string:
+		return json.Unmarshal([]byte(data), ops)
+	}
+	return fmt.Errorf("can not scan %T into operations", src)
+}
+
+// ParseAspect - parses aspect ratio given in "width:height" format
+func ParseAspect(aspect string) (int, int, error) {
+	var parts = strings.Split(aspect, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("aspect should be in 'width:height' format, got %q", aspect)
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid aspect width %q", parts[0])
+	}
+	height, err := strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid aspect height %q", parts[1])
+	}
+	return width, height, nil
+}
+
+// AspectRatio - returns width to height ratio of aspect given in "width:height" format, 0 if it is invalid
+func AspectRatio(aspect string) float64 {
+	var width, height, err = ParseAspect(aspect)
+	if err != nil {
+		return 0
+	}
+	return float64(width) / float64(height)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate - checks that operation has all required parameters
+func (op *Operation) Validate() error {
+	switch op.Op {
+	case OpResize:
+		switch op.Mode {
+		case "", ResizeFit:
+			if op.Width <= 0 && op.Height <= 0 {
+				return errors.New("resize requires width or height")
+			}
+		case ResizeFill, ResizeForce:
+			if op.Width <= 0 || op.Height <= 0 {
+				return fmt.Errorf("resize with mode %q requires width and height", op.Mode)
+			}
+		default:
+			return fmt.Errorf("unknown resize mode %q", op.Mode)
+		}
+		if !contains(Gravities, op.Gravity) {
+			return fmt.Errorf("unknown gravity %q", op.Gravity)
+		}
+	case OpCrop:
+		if op.Aspect != "" {
+			if _, _, err := ParseAspect(op.Aspect); err != nil {
+				return err
+			}
+		} else if op.Width <= 0 || op.Height <= 0 {
+			return errors.New("crop requires aspect or width and height")
+		}
+		if op.X < 0 || op.Y < 0 {
+			return errors.New("crop x and y should not be negative")
+		}
+		if !contains(Gravities, op.Gravity) {
+			return fmt.Errorf("unknown gravity %q", op.Gravity)
+		}
+	case OpRotate:
+		if op.Angle != 90 && op.Angle != 180 && op.Angle != 270 {
+			return fmt.Errorf("rotate angle should be one of 90, 180, 270, got %v", op.Angle)
+		}
+	case OpFlip:
+		if op.Direction != "" && op.Direction != "horizontal" && op.Direction != "vertical" {
+			return fmt.Errorf("unknown flip direction %q", op.Direction)
+		}
+	case OpBlur:
+		if op.Sigma <= 0 {
+			return errors.New("blur requires positive sigma")
+		}
+	case OpSharpen:
+		if op.Radius < 0 {
+			return errors.New("sharpen radius should not be negative")
+		}
+	case OpGrayscale:
+	case OpFormat:
+		if !contains(Formats, op.Format) {
+			return fmt.Errorf("unsupported format %q", op.Format)
+		}
+	case OpQuality:
+		if op.Quality <= 0 || op.Quality > 100 {
+			return fmt.Errorf("quality should be in range 1..100, got %v", op.Quality)
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+	return nil
+}
+
+// Validate - checks transformation description
+func (t *Transformation) Validate() error {
+	if t.Name == "" {
+		return errors.New("transformation name is required")
+	}
+	if t.KeepAnimation && !contains(AnimatedTransformTypes, t.Type) {
+		return fmt.Errorf("transformation %q: animation can be kept only by %v types", t.Name, AnimatedTransformTypes)
+	}
+	if !contains(AnimationFormats, t.AnimationFormat) {
+		return fmt.Errorf("transformation %q: unsupported animation format %q", t.Name, t.AnimationFormat)
+	}
+	if t.Type != ChainTransformType {
+		if len(t.Operations) > 0 {
+			return fmt.Errorf("transformation %q: operations can be used only with %q type", t.Name, ChainTransformType)
+		}
+		return nil
+	}
+	if len(t.Operations) == 0 {
+		return fmt.Errorf("transformation %q: chain requires at least one operation", t.Name)
+	}
+	for i := range t.Operations {
+		if err := t.Operations[i].Validate(); err != nil {
+			return fmt.Errorf("transformation %q: operation #%v: %v", t.Name, i, err)
+		}
+	}
+	return nil
+}
+
+// Format - returns output format set by operations, empty string means jpeg
+func (t *Transformation) Format() string {
+	var format string
+	for _, op := range t.Operations {
+		if op.Op == OpFormat {
+			format = op.Format
+		}
+	}
+	return format
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransformationValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	var square = Transformation{
+		Name: "square_540",
+		Type: ChainTransformType,
+		Operations: Operations{
+			{Op: OpCrop, Aspect: "1:1"},
+			{Op: OpResize, Width: 540, Height: 540},
+			{Op: OpSharpen},
+			{Op: OpFormat, Format: "webp"},
+		},
+	}
+	assert.NoError(square.Validate())
+	assert.Equal("webp", square.Format())
+
+	var legacy = Transformation{Name: "legacy", Type: "fit", Width: 100, Height: 100}
+	assert.NoError(legacy.Validate())
+	assert.Equal("", legacy.Format())
+
+	assert.Error((&Transformation{Name: "empty", Type: ChainTransformType}).Validate())
+	assert.Error((&Transformation{Name: "mixed", Type: "fit", Operations: Operations{{Op: OpGrayscale}}}).Validate())
+
+	assert.NoError((&Transformation{Name: "animated", Type: "fill", KeepAnimation: true, AnimationFormat: "gif"}).Validate())
+	assert.Error((&Transformation{Name: "animated_crop", Type: "crop", KeepAnimation: true}).Validate())
+	assert.Error((&Transformation{Name: "animated_png", Type: "fit", KeepAnimation: true, AnimationFormat: "png"}).Validate())
+}
+
+func TestOperationValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	var invalid = []Operation{
+		{Op: "unknown"},
+		{Op: OpResize},
+		{Op: OpResize, Mode: ResizeFill, Width: 100},
+		{Op: OpResize, Mode: "stretch", Width: 100},
+		{Op: OpCrop, Aspect: "1x1"},
+		{Op: OpCrop, Width: 10},
+		{Op: OpCrop, Width: 10, Height: 10, Gravity: "somewhere"},
+		{Op: OpRotate, Angle: 45},
+		{Op: OpFlip, Direction: "diagonal"},
+		{Op: OpBlur},
+		{Op: OpFormat, Format: "bmp"},
+		{Op: OpQuality, Quality: 101},
+	}
+	for _, op := range invalid {
+		assert.Error(op.Validate(), "operation %+v should be invalid", op)
+	}
+
+	var valid = []Operation{
+		{Op: OpResize, Width: 100},
+		{Op: OpResize, Mode: ResizeForce, Width: 100, Height: 50},
+		{Op: OpCrop, X: 10, Y: 10, Width: 10, Height: 10},
+		{Op: OpCrop, Aspect: "4:3", Gravity: "north"},
+		{Op: OpRotate, Angle: 270},
+		{Op: OpFlip, Direction: "vertical"},
+		{Op: OpBlur, Sigma: 1.5},
+		{Op: OpGrayscale},
+		{Op: OpQuality, Quality: 80},
+	}
+	for _, op := range valid {
+		assert.NoError(op.Validate(), "operation %+v should be valid", op)
+	}
+}
+
+func TestOperationsScan(t *testing.T) {
+	assert := assert.New(t)
+
+	var ops = Operations{{Op: OpResize, Width: 100}, {Op: OpGrayscale}}
+	value, err := ops.Value()
+	assert.NoError(err)
+
+	var scanned Operations
+	assert.NoError(scanned.Scan(value))
+	assert.Equal(ops, scanned)
+
+	assert.NoError(scanned.Scan(nil))
+	assert.Nil(scanned)
+
+	value, err = Operations{}.Value()
+	assert.NoError(err)
+	assert.Nil(value)
+}
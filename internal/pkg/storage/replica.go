@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ObjectStore - storage which "real" copies are replicated to,
+// objects are encrypted with encryption of "real" copy, nil value means no encryption
+type ObjectStore interface {
+	PutEncryptedObject(objectKey string, data []byte, encryption *Encryption) error
+	// GetEncryptedObject - returns NoSuchKeyError if object does not exist
+	GetEncryptedObject(objectKey string, encryption *Encryption) ([]byte, error)
+	// DeleteObject - it is not an error if object does not exist
+	DeleteObject(objectKey string) error
+}
+
+// InitReplica - returns store of REPLICA_PROFILE or REPLICA_DIR, it is nil if replication is disabled
+func InitReplica(cfg *utils.Config, ctx *S3Context) (ObjectStore, error) {
+	if cfg.ReplicaProfile != "" && cfg.ReplicaDir != "" {
+		return nil, errors.New("only one of REPLICA_PROFILE and REPLICA_DIR can be set")
+	}
+	if cfg.ReplicaDir != "" && cfg.SSE != SSENone {
+		return nil, errors.New("REPLICA_DIR can not encrypt objects, use REPLICA_PROFILE with SSE")
+	}
+	if cfg.ReplicaProfile != "" {
+		var profile, err = ctx.Profile(cfg.ReplicaProfile)
+		if err != nil {
+			return nil, err
+		}
+		return profile, nil
+	}
+	if cfg.ReplicaDir != "" {
+		return NewFileStore(cfg.ReplicaDir)
+	}
+	return nil, nil
+}
+
+// PutEncryptedObject - uploads private object, content type is detected by data
+func (ctx *S3Context) PutEncryptedObject(objectKey string, data []byte, encryption *Encryption) error {
+	var _, err = ctx.UploadFileWithContext(context.Background(), bytes.NewReader(data), objectKey, ObjectOptions{
+		ContentType: http.DetectContentType(data),
+		Private:     true,
+		Encryption:  encryption,
+	})
+	return err
+}
+
+var errFileStoreEncryption = errors.New("objects of file store can not be encrypted")
+
+// FileStore - ObjectStore keeping objects as files under root directory, it can not encrypt them
+type FileStore struct {
+	root string
+}
+
+// NewFileStore - creates root directory if it does not exist
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{root: root}, nil
+}
+
+// filePath - returns path of object file, keys are cleaned so they can not point outside of root
+func (fs *FileStore) filePath(objectKey string) string {
+	return filepath.Join(fs.root, filepath.FromSlash(path.Clean("/"+objectKey)))
+}
+
+// PutEncryptedObject - writes object to temporary file and renames it, so partially written objects are never read
+func (fs *FileStore) PutEncryptedObject(objectKey string, data []byte, encryption *Encryption) error {
+	if encryption != nil {
+		return errFileStoreEncryption
+	}
+	var name = fs.filePath(objectKey)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".replica-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (fs *FileStore) GetEncryptedObject(objectKey string, encryption *Encryption) ([]byte, error) {
+	if encryption != nil {
+		return nil, errFileStoreEncryption
+	}
+	var data, err = ioutil.ReadFile(fs.filePath(objectKey))
+	if os.IsNotExist(err) {
+		return nil, NoSuchKeyError
+	}
+	return data, err
+}
+
+func (fs *FileStore) DeleteObject(objectKey string) error {
+	var err = os.Remove(fs.filePath(objectKey))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := ioutil.TempDir("", "louis-replica")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	store, err := NewFileStore(root)
+	assert.NoError(err)
+
+	_, err = store.GetEncryptedObject("key/real.jpg", nil)
+	assert.Equal(NoSuchKeyError, err, "missing object should not be found")
+
+	assert.NoError(store.PutEncryptedObject("key/real.jpg", []byte("image"), nil))
+	data, err := store.GetEncryptedObject("key/real.jpg", nil)
+	assert.NoError(err)
+	assert.Equal([]byte("image"), data)
+
+	assert.NoError(store.PutEncryptedObject("../../escape.jpg", []byte("image"), nil))
+	assert.FileExists(filepath.Join(root, "escape.jpg"), "object key should not point outside of root")
+
+	assert.NoError(store.DeleteObject("key/real.jpg"))
+	assert.NoError(store.DeleteObject("key/real.jpg"), "deleting missing object should not fail")
+	_, err = store.GetEncryptedObject("key/real.jpg", nil)
+	assert.Equal(NoSuchKeyError, err)
+
+	var encryption, _ = NewEncryption(SSES3, "", "")
+	assert.Error(store.PutEncryptedObject("key/real.jpg", []byte("image"), encryption), "file store should not keep encrypted objects decrypted")
+}
+
+func TestInitReplica(t *testing.T) {
+	_, err := InitReplica(&utils.Config{ReplicaDir: os.TempDir(), SSE: SSES3}, nil)
+	assert.Error(t, err, "replica directory should not be used with encryption")
+}
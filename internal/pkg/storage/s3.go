@@ -1,19 +1,20 @@
 package storage
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"time"
 )
 
 // ObjectID - is a shortcut for s3.ObjectIdentifier
@@ -25,26 +26,116 @@ var NoSuchKeyError = errors.New("no such key")
 type S3Context struct {
 	session *session.Session
 	config  *utils.Config
+	// bucket - S3_BUCKET or bucket of storage profile
+	bucket string
+	// profiles - contexts of S3_PROFILES, they are set only in default context
+	profiles map[string]*S3Context
+	compat   Compat
 }
 
-// InitS3Context - creates and inits session for s3
-func InitS3Context(cfg *utils.Config) (*S3Context, error) {
-	var ctx = &S3Context{
-		config: cfg,
+// ObjectOptions - how uploaded or copied object is stored
+type ObjectOptions struct {
+	// ContentType - on copy headers and metadata are replaced if it is set, otherwise they are copied from source
+	ContentType string
+	// CacheControl, ContentDisposition and Metadata - headers of object, metadata is sent as x-amz-meta-<key>
+	CacheControl       string
+	ContentDisposition string
+	Metadata           map[string]string
+	// Private - object can be read only with credentials or presigned URL
+	Private bool
+	// StorageClass - S3 storage class, empty value means default class of bucket
+	StorageClass string
+	// Encryption - server side encryption of object, SourceEncryption - customer key of copied object
+	Encryption       *Encryption
+	SourceEncryption *Encryption
+}
+
+// StorageClasses - allowed storage classes of objects, archive classes are not allowed
+// because objects of them can not be read without restoring
+var StorageClasses = []string{
+	"",
+	s3.StorageClassStandard,
+	s3.StorageClassReducedRedundancy,
+	s3.StorageClassStandardIa,
+	s3.StorageClassOnezoneIa,
+	s3.StorageClassIntelligentTiering,
+}
+
+// ValidStorageClass - reports whether class is one of StorageClasses
+func ValidStorageClass(class string) bool {
+	return contains(StorageClasses, class)
+}
+
+// optionalString - returns nil for empty value, so header is not sent
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
 	}
-	var err error
-	ctx.session, err = session.NewSession(&aws.Config{
-		Endpoint: aws.String(cfg.S3Endpoint),
-		Region:   aws.String(cfg.S3Region),
+	return aws.String(value)
+}
+
+func newSession(endpoint, region, accessKeyID, secretAccessKey string, compat Compat) (*session.Session, error) {
+	return session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(compat.PathStyle),
 		Credentials: credentials.NewStaticCredentials(
-			cfg.S3AccessKeyID,
-			cfg.S3SecretAccessKey,
+			accessKeyID,
+			secretAccessKey,
 			"",
 		),
 
 		// LogLevel: aws.LogLevel(aws.LogDebugWithHTTPBody),
 	})
-	return ctx, err
+}
+
+// InitS3Context - creates and inits session for s3 and storage profiles
+func InitS3Context(cfg *utils.Config) (*S3Context, error) {
+	var ctx = &S3Context{
+		config:   cfg,
+		bucket:   cfg.S3Bucket,
+		profiles: make(map[string]*S3Context, len(cfg.S3ProfileSettings)),
+	}
+	var err error
+	if ctx.compat, err = CompatProfile(cfg.S3Compat); err != nil {
+		return ctx, err
+	}
+	ctx.session, err = newSession(cfg.S3Endpoint, cfg.S3Region, cfg.S3AccessKeyID, cfg.S3SecretAccessKey, ctx.compat)
+	if err != nil {
+		return ctx, err
+	}
+	for name, profile := range cfg.S3ProfileSettings {
+		var profileCtx = &S3Context{config: cfg, bucket: profile.Bucket}
+		if profileCtx.compat, err = CompatProfile(profile.Compat); err != nil {
+			return ctx, fmt.Errorf("storage profile %q: %v", name, err)
+		}
+		profileCtx.session, err = newSession(profile.Endpoint, profile.Region, profile.AccessKeyID, profile.SecretAccessKey, profileCtx.compat)
+		if err != nil {
+			return ctx, fmt.Errorf("storage profile %q: %v", name, err)
+		}
+		ctx.profiles[name] = profileCtx
+	}
+	return ctx, nil
+}
+
+// Profile - returns context of storage profile, empty name means default storage
+func (ctx *S3Context) Profile(name string) (*S3Context, error) {
+	if name == "" {
+		return ctx, nil
+	}
+	if profile, ok := ctx.profiles[name]; ok {
+		return profile, nil
+	}
+	return nil, fmt.Errorf("unknown storage profile %q", name)
+}
+
+// service - returns S3 client with request handlers of provider quirks
+func (ctx *S3Context) service() *s3.S3 {
+	var service = s3.New(ctx.session)
+	if ctx.compat.StripXMLNS {
+		service.Handlers.Build.PushBack(stripXMLNS)
+	}
+	return service
 }
 
 // TODO: make storage context
@@ -54,10 +145,10 @@ func (ctx *S3Context) UploadFile(file io.Reader, objectKey string) (string, erro
 
 	manager := s3manager.NewUploader(ctx.session)
 	out, err := manager.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(ctx.config.S3Bucket),
+		Bucket: aws.String(ctx.bucket),
 		Body:   file,
 		Key:    aws.String(objectKey),
-		ACL:    aws.String("public-read"),
+		ACL:    ctx.objectACL(false),
 		// We assume that image is already converted to jpg
 		ContentType: aws.String("image/jpeg"),
 	})
@@ -65,17 +156,42 @@ func (ctx *S3Context) UploadFile(file io.Reader, objectKey string) (string, erro
 	return out.Location, err
 }
 
-// UploadFileWithContext - uploads the file with objectKey key with context
-func (ctx *S3Context) UploadFileWithContext(cctx context.Context, file io.Reader, objectKey string) (string, error) {
+// SupportsACL - reports whether private objects can be stored, otherwise visibility is controlled by bucket policy
+func (ctx *S3Context) SupportsACL() bool {
+	return ctx.compat.ACL
+}
+
+// objectACL - returns canned ACL of uploaded objects, it is not sent if provider does not support ACLs
+func (ctx *S3Context) objectACL(private bool) *string {
+	if !ctx.compat.ACL {
+		return nil
+	}
+	if private {
+		return aws.String(s3.ObjectCannedACLPrivate)
+	}
+	return aws.String(s3.ObjectCannedACLPublicRead)
+}
+
+// UploadFileWithContext - uploads the file with objectKey key and given options with context
+func (ctx *S3Context) UploadFileWithContext(cctx context.Context, file io.Reader, objectKey string, opts ObjectOptions) (string, error) {
 
+	var sse, kmsKeyID = opts.Encryption.serverSideEncryption()
+	var customerAlgorithm, customerKey = opts.Encryption.customer()
 	manager := s3manager.NewUploader(ctx.session)
 	out, err := manager.UploadWithContext(cctx, &s3manager.UploadInput{
-		Bucket: aws.String(ctx.config.S3Bucket),
-		Body:   file,
-		Key:    aws.String(objectKey),
-		ACL:    aws.String("public-read"),
-		// We assume that image is already converted to jpg
-		ContentType: aws.String("image/jpeg"),
+		Bucket:               aws.String(ctx.bucket),
+		Body:                 file,
+		Key:                  aws.String(objectKey),
+		ACL:                  ctx.objectACL(opts.Private),
+		ContentType:          aws.String(opts.ContentType),
+		CacheControl:         optionalString(opts.CacheControl),
+		ContentDisposition:   optionalString(opts.ContentDisposition),
+		Metadata:             aws.StringMap(opts.Metadata),
+		StorageClass:         optionalString(opts.StorageClass),
+		ServerSideEncryption: sse,
+		SSEKMSKeyId:          kmsKeyID,
+		SSECustomerAlgorithm: customerAlgorithm,
+		SSECustomerKey:       customerKey,
 	})
 
 	if err != nil {
@@ -85,26 +201,52 @@ func (ctx *S3Context) UploadFileWithContext(cctx context.Context, file io.Reader
 	return out.Location, err
 }
 
-// CopyObject - make a copy of object
-func (ctx *S3Context) CopyObject(source, dest string) error {
-
-	var service = s3.New(ctx.session)
-	var _, err = service.CopyObject(&s3.CopyObjectInput{
-		CopySource: aws.String(ctx.config.S3Bucket + "/" + source),
-		Key:        aws.String(dest),
-		ACL:        aws.String("public-read"),
-		Bucket:     aws.String(ctx.config.S3Bucket),
-	})
+// CopyObject - make a copy of object in the same bucket, object can be copied to itself to change encryption
+func (ctx *S3Context) CopyObject(source, dest string, opts ObjectOptions) error {
+
+	var sse, kmsKeyID = opts.Encryption.serverSideEncryption()
+	var customerAlgorithm, customerKey = opts.Encryption.customer()
+	var sourceAlgorithm, sourceKey = opts.SourceEncryption.customer()
+	var input = &s3.CopyObjectInput{
+		CopySource:                     aws.String(ctx.bucket + "/" + source),
+		Key:                            aws.String(dest),
+		ACL:                            ctx.objectACL(opts.Private),
+		Bucket:                         aws.String(ctx.bucket),
+		StorageClass:                   optionalString(opts.StorageClass),
+		ServerSideEncryption:           sse,
+		SSEKMSKeyId:                    kmsKeyID,
+		SSECustomerAlgorithm:           customerAlgorithm,
+		SSECustomerKey:                 customerKey,
+		CopySourceSSECustomerAlgorithm: sourceAlgorithm,
+		CopySourceSSECustomerKey:       sourceKey,
+	}
+	if opts.ContentType != "" {
+		input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+		input.ContentType = aws.String(opts.ContentType)
+		input.CacheControl = optionalString(opts.CacheControl)
+		input.ContentDisposition = optionalString(opts.ContentDisposition)
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+	var service = ctx.service()
+	var _, err = service.CopyObject(input)
 
 	return err
 }
 
 // GetObject - returns s3 object content
 func (ctx *S3Context) GetObject(objectKey string) ([]byte, error) {
-	var service = s3.New(ctx.session)
+	return ctx.GetEncryptedObject(objectKey, nil)
+}
+
+// GetEncryptedObject - returns content of object, customer key of encryption is sent to decrypt it
+func (ctx *S3Context) GetEncryptedObject(objectKey string, encryption *Encryption) ([]byte, error) {
+	var customerAlgorithm, customerKey = encryption.customer()
+	var service = ctx.service()
 	object, err := service.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(ctx.config.S3Bucket),
-		Key:    aws.String(objectKey),
+		Bucket:               aws.String(ctx.bucket),
+		Key:                  aws.String(objectKey),
+		SSECustomerAlgorithm: customerAlgorithm,
+		SSECustomerKey:       customerKey,
 	})
 
 	if err != nil {
@@ -120,13 +262,83 @@ func (ctx *S3Context) GetObject(objectKey string) ([]byte, error) {
 	return ioutil.ReadAll(object.Body)
 }
 
+// PresignPutObject - returns URL which can be used to upload object without credentials until it expires
+func (ctx *S3Context) PresignPutObject(objectKey string, expires time.Duration) (string, error) {
+	var service = ctx.service()
+	var req, _ = service.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(ctx.bucket),
+		Key:    aws.String(objectKey),
+	})
+	return req.Presign(expires)
+}
+
+// PresignGetObject - returns URL which can be used to read object without credentials until it expires
+func (ctx *S3Context) PresignGetObject(objectKey string, expires time.Duration) (string, error) {
+	var service = ctx.service()
+	var req, _ = service.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(ctx.bucket),
+		Key:    aws.String(objectKey),
+	})
+	return req.Presign(expires)
+}
+
+// headObject - returns headers of object, NoSuchKeyError if it does not exist,
+// customer key of encryption is required to read headers of object encrypted with it
+func (ctx *S3Context) headObject(objectKey string, encryption *Encryption) (*s3.HeadObjectOutput, error) {
+	var customerAlgorithm, customerKey = encryption.customer()
+	var service = ctx.service()
+	head, err := service.HeadObject(&s3.HeadObjectInput{
+		Bucket:               aws.String(ctx.bucket),
+		Key:                  aws.String(objectKey),
+		SSECustomerAlgorithm: customerAlgorithm,
+		SSECustomerKey:       customerKey,
+	})
+
+	if err != nil {
+		// HEAD response has no body, so there is no s3.ErrCodeNoSuchKey
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+			return nil, NoSuchKeyError
+		}
+		return nil, err
+	}
+	return head, nil
+}
+
+// ObjectSize - returns size of object in bytes
+func (ctx *S3Context) ObjectSize(objectKey string) (int64, error) {
+	var head, err = ctx.headObject(objectKey, nil)
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(head.ContentLength), nil
+}
+
+// ObjectContentType - returns content type object is stored with, encryption is the one object is encrypted with
+func (ctx *S3Context) ObjectContentType(objectKey string, encryption *Encryption) (string, error) {
+	var head, err = ctx.headObject(objectKey, encryption)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(head.ContentType), nil
+}
+
+// DeleteObject - deletes one object, it is not an error if object does not exist
+func (ctx *S3Context) DeleteObject(objectKey string) error {
+	var service = ctx.service()
+	var _, err = service.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(ctx.bucket),
+		Key:    aws.String(objectKey),
+	})
+	return err
+}
+
 // ListFiles - list all objects with prefix
 func (ctx *S3Context) ListFiles(prefix string) ([]ObjectID, error) {
 
-	svc := s3.New(ctx.session)
+	svc := ctx.service()
 
 	objects, err := svc.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(ctx.config.S3Bucket),
+		Bucket: aws.String(ctx.bucket),
 		Prefix: aws.String(prefix),
 	})
 
@@ -142,29 +354,42 @@ func (ctx *S3Context) ListFiles(prefix string) ([]ObjectID, error) {
 	return obIdentifiers, nil
 }
 
-// DeleteFiles - deletes objects from s3
+// DeleteFiles - deletes objects from s3, they are deleted one by one
+// if provider does not support DeleteObjects
 func (ctx *S3Context) DeleteFiles(obIdentifiers []ObjectID) error {
-	svc := s3.New(ctx.session)
-
-	svc.Handlers.Build.PushBack(func(r *request.Request) {
-
-		if r.Operation.Name == "DeleteObjects" {
-			buf := new(bytes.Buffer)
-			var _, err = buf.ReadFrom(r.Body)
-			if err == nil {
-				updated := bytes.Replace(buf.Bytes(), []byte(` xmlns="http://s3.amazonaws.com/doc/2006-03-01/"`), []byte(""), -1)
-				r.SetReaderBody(bytes.NewReader(updated))
-			}
-		}
-	})
+	if len(obIdentifiers) == 0 {
+		return nil
+	}
+	if !ctx.compat.MultiDelete {
+		return ctx.deleteOneByOne(obIdentifiers)
+	}
 
-	var _, err = svc.DeleteObjects(&s3.DeleteObjectsInput{
-		Bucket: aws.String(ctx.config.S3Bucket),
+	var out, err = ctx.service().DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(ctx.bucket),
 		Delete: &s3.Delete{
 			Objects: obIdentifiers,
 		},
 	})
-	return err
+	if aerr, ok := err.(awserr.RequestFailure); ok && (aerr.StatusCode() == http.StatusNotImplemented || aerr.Code() == "NotImplemented") {
+		return ctx.deleteOneByOne(obIdentifiers)
+	}
+	if err != nil {
+		return err
+	}
+	if len(out.Errors) > 0 {
+		var first = out.Errors[0]
+		return fmt.Errorf("failed to delete %v objects, %v: %v", len(out.Errors), aws.StringValue(first.Key), aws.StringValue(first.Message))
+	}
+	return nil
+}
+
+func (ctx *S3Context) deleteOneByOne(obIdentifiers []ObjectID) error {
+	for _, id := range obIdentifiers {
+		if err := ctx.DeleteObject(aws.StringValue(id.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // DeleteFolder - Deletes all files with given prefix
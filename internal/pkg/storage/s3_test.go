@@ -1,12 +1,15 @@
 package storage
 
 import (
+	"context"
 	"github.com/KazanExpress/louis/internal/pkg/utils"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/suite"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 type S3TestSuite struct {
@@ -112,6 +115,31 @@ func (s *S3TestSuite) TestDeleteFolder() {
 
 }
 
+func (s *S3TestSuite) TestPrivateObject() {
+
+	var filename = "../../../test/data/picture.jpg"
+
+	f, ferr := os.Open(filename)
+	s.NoError(ferr, "should be able to open file")
+	defer f.Close()
+	defer s.ctx.DeleteFolder("test")
+
+	location, err := s.ctx.UploadFileWithContext(context.Background(), f, "test/private.jpg", ObjectOptions{ContentType: "image/jpeg", Private: true})
+	s.NoError(err, "should be uploaded successfully")
+
+	resp, err := http.Get(location)
+	s.NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusForbidden, resp.StatusCode, "private object should not be public")
+
+	url, err := s.ctx.PresignGetObject("test/private.jpg", time.Minute)
+	s.NoError(err)
+	resp, err = http.Get(url)
+	s.NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode, "private object should be available by presigned url")
+}
+
 func (s *S3TestSuite) TestCopyObject() {
 
 	var filename = "../../../test/data/picture.jpg"
@@ -128,8 +156,17 @@ func (s *S3TestSuite) TestCopyObject() {
 	_, err := s.ctx.UploadFile(f, fileKey)
 	s.NoError(err, "should be uploaded successfully")
 
-	err = s.ctx.CopyObject(fileKey, copyFileKey)
+	err = s.ctx.CopyObject(fileKey, copyFileKey, ObjectOptions{})
 	s.NoError(err, "should be copied successfully")
 
 	_ = s.ctx.DeleteFolder("test")
 }
+
+func (s *S3TestSuite) TestProfile() {
+	var ctx, err = s.ctx.Profile("")
+	s.NoError(err)
+	s.Equal(s.ctx, ctx, "empty profile should be default storage")
+
+	_, err = s.ctx.Profile("unknown")
+	s.Error(err, "unknown profile should not be found")
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// VariantName - returns name of transformation generated for given density
+func (set *TransformationSet) VariantName(density int) string {
+	return fmt.Sprintf("%s_%dx", set.Name, density)
+}
+
+// Validate - checks set description
+func (set *TransformationSet) Validate() error {
+	if set.Name == "" {
+		return errors.New("transformation set name is required")
+	}
+	if len(set.Densities) == 0 {
+		return fmt.Errorf("transformation set %q: at least one density is required", set.Name)
+	}
+	var seen = make(map[int]bool)
+	for _, density := range set.Densities {
+		if density <= 0 {
+			return fmt.Errorf("transformation set %q: density should be positive, got %v", set.Name, density)
+		}
+		if seen[density] {
+			return fmt.Errorf("transformation set %q: duplicated density %v", set.Name, density)
+		}
+		seen[density] = true
+	}
+	return nil
+}
+
+// Variants - generates transformation for each density of set
+func (set *TransformationSet) Variants() []Transformation {
+	var variants = make([]Transformation, 0, len(set.Densities))
+	for _, density := range set.Densities {
+		var ops Operations
+		if len(set.Operations) > 0 {
+			ops = make(Operations, len(set.Operations))
+			for i, op := range set.Operations {
+				// all pixel dimensions are scaled, aspect ratios stay the same
+				op.Width *= density
+				op.Height *= density
+				op.X *= density
+				op.Y *= density
+				ops[i] = op
+			}
+		}
+		variants = append(variants, Transformation{
+			Name:       set.VariantName(density),
+			Tag:        set.Tag,
+			Type:       set.Type,
+			Quality:    set.Quality,
+			Width:      set.Width * density,
+			Height:     set.Height * density,
+			Operations: ops,
+			Set:        set.Name,
+			Density:    density,
+			Sizes:      set.Sizes,
+
+			KeepCopyright: set.KeepCopyright,
+			KeepICC:       set.KeepICC,
+
+			KeepAnimation:   set.KeepAnimation,
+			AnimationFormat: set.AnimationFormat,
+
+			CacheControl:       set.CacheControl,
+			ContentDisposition: set.ContentDisposition,
+		})
+	}
+	return variants
+}
+
+// Validate - checks tag settings description
+func (ts *TagSettings) Validate() error {
+	if ts.Tag == "" {
+		return errors.New("tag settings require tag")
+	}
+	for _, aspect := range []string{ts.MinAspect, ts.MaxAspect} {
+		if aspect == "" {
+			continue
+		}
+		if _, _, err := ParseAspect(aspect); err != nil {
+			return fmt.Errorf("tag %q: %v", ts.Tag, err)
+		}
+	}
+	if ts.MinAspect != "" && ts.MaxAspect != "" && AspectRatio(ts.MinAspect) > AspectRatio(ts.MaxAspect) {
+		return fmt.Errorf("tag %q: minAspect %v is greater than maxAspect %v", ts.Tag, ts.MinAspect, ts.MaxAspect)
+	}
+	if !contains(DedupModes, ts.Dedup) {
+		return fmt.Errorf("tag %q: unknown dedup mode %q", ts.Tag, ts.Dedup)
+	}
+	if !ValidStorageClass(ts.RealStorageClass) {
+		return fmt.Errorf("tag %q: unsupported storage class %q", ts.Tag, ts.RealStorageClass)
+	}
+	return nil
+}
+
+// All - returns transformations together with variants of all sets
+func (list *TransformList) All() ([]Transformation, error) {
+	var all = append([]Transformation{}, list.Transformations...)
+	for i := range list.Sets {
+		if err := list.Sets[i].Validate(); err != nil {
+			return nil, err
+		}
+		all = append(all, list.Sets[i].Variants()...)
+	}
+	return all, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransformListAll(t *testing.T) {
+	assert := assert.New(t)
+
+	var list = TransformList{
+		Transformations: tlist,
+		Sets: []TransformationSet{
+			{
+				Name:         "card",
+				Tag:          "product",
+				Type:         ChainTransformType,
+				Quality:      80,
+				Densities:    []int{1, 2, 3},
+				Sizes:        "240px",
+				CacheControl: "max-age=86400",
+				Operations: Operations{
+					{Op: OpCrop, Aspect: "3:4"},
+					{Op: OpResize, Width: 240, Height: 320},
+				},
+			},
+		},
+	}
+
+	all, err := list.All()
+	assert.NoError(err)
+	assert.Equal(len(tlist)+3, len(all))
+
+	var variant = all[len(tlist)+1]
+	assert.Equal("card_2x", variant.Name)
+	assert.Equal("card", variant.Set)
+	assert.Equal(2, variant.Density)
+	assert.Equal("240px", variant.Sizes)
+	assert.Equal("max-age=86400", variant.CacheControl)
+	assert.Equal(480, variant.Operations[1].Width)
+	assert.Equal(640, variant.Operations[1].Height)
+	assert.Equal("3:4", variant.Operations[0].Aspect)
+	// source set should stay untouched
+	assert.Equal(240, list.Sets[0].Operations[1].Width)
+
+	list.Sets[0].Densities = []int{1, 1}
+	_, err = list.All()
+	assert.Error(err)
+}
+
+func TestTagSettingsValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&TagSettings{Tag: "shop", MinAspect: "4:1", MaxAspect: "8:1"}).Validate())
+	assert.Error((&TagSettings{MinWidth: 800}).Validate())
+	assert.Error((&TagSettings{Tag: "shop", MinAspect: "wide"}).Validate())
+	assert.Error((&TagSettings{Tag: "shop", MinAspect: "8:1", MaxAspect: "4:1"}).Validate())
+	assert.NoError((&TagSettings{Tag: "shop", Dedup: DedupCopy}).Validate())
+	assert.Error((&TagSettings{Tag: "shop", Dedup: "always"}).Validate())
+	assert.NoError((&TagSettings{Tag: "shop", RealStorageClass: "STANDARD_IA"}).Validate())
+	assert.Error((&TagSettings{Tag: "shop", RealStorageClass: "GLACIER"}).Validate())
+}
@@ -0,0 +1,79 @@
+package transformations
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// resize_animated - loads all frames of image and resizes them to fit (or fill with crop) given box
+static int
+resize_animated(void *buf, size_t len, int width, int height, int crop, const char *suffix, void **out, size_t *out_len) {
+	VipsImage *image;
+	int err = crop ?
+		vips_thumbnail_buffer(buf, len, &image, width, "height", height, "option_string", "n=-1",
+			"crop", VIPS_INTERESTING_CENTRE, "size", VIPS_SIZE_BOTH, NULL) :
+		vips_thumbnail_buffer(buf, len, &image, width, "height", height, "option_string", "n=-1",
+			"size", VIPS_SIZE_DOWN, NULL);
+	if (err != 0) {
+		return err;
+	}
+	err = vips_image_write_to_buffer(image, suffix, out, out_len, "strip", TRUE, NULL);
+	g_object_unref(image);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"gopkg.in/h2non/bimg.v1"
+	"unsafe"
+)
+
+// AnimationFormat - returns format of animated output of transformation
+func AnimationFormat(trans *storage.Transformation) string {
+	if trans.AnimationFormat == "" {
+		return "webp"
+	}
+	return trans.AnimationFormat
+}
+
+// ResizeAnimated - resizes every frame of animated gif or webp image,
+// fill crops frames to exact size, otherwise they fit into given box
+func ResizeAnimated(buffer ImageBuffer, width, height int, fill bool, format string, quality int) (ImageBuffer, error) {
+	if len(buffer) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+	var suffix = ".gif"
+	if format != "gif" {
+		suffix = fmt.Sprintf(".webp[Q=%d]", quality)
+	}
+	var cSuffix = C.CString(suffix)
+	defer C.free(unsafe.Pointer(cSuffix))
+
+	var crop C.int
+	if fill {
+		crop = 1
+	}
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.resize_animated(unsafe.Pointer(&buffer[0]), C.size_t(len(buffer)), C.int(width), C.int(height), crop, cSuffix, &out, &length) != 0 {
+		var err = errors.New(C.GoString(C.vips_error_buffer()))
+		C.vips_error_clear()
+		return nil, err
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
+
+// Poster - returns first frame of image as jpeg
+func Poster(buffer ImageBuffer, quality int) (ImageBuffer, error) {
+	return bimg.NewImage(buffer).Process(bimg.Options{
+		Type:          bimg.JPEG,
+		Quality:       quality,
+		StripMetadata: true,
+		Interlace:     true, // adds progressive jpeg support
+	})
+}
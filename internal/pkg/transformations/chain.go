@@ -0,0 +1,243 @@
+package transformations
+
+import (
+	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+)
+
+// bimg applies options of single Process call in fixed order,
+// so consecutive operations are merged into one libvips pass
+// only while their stages are strictly increasing
+const (
+	stageNone = iota
+	stageRotate
+	stageFlip
+	stageResize
+	stageExtract
+	stageBlur
+	stageSharpen
+)
+
+var gravities = map[string]bimg.Gravity{
+	"":       bimg.GravityCentre,
+	"centre": bimg.GravityCentre,
+	"north":  bimg.GravityNorth,
+	"east":   bimg.GravityEast,
+	"south":  bimg.GravitySouth,
+	"west":   bimg.GravityWest,
+}
+
+var formats = map[string]bimg.ImageType{
+	"jpeg": bimg.JPEG,
+	"png":  bimg.PNG,
+	"webp": bimg.WEBP,
+}
+
+type chainPipeline struct {
+	buffer  ImageBuffer
+	options bimg.Options
+	stage   int
+	// size of image after all added operations
+	width, height int
+	autoRotated   bool
+}
+
+// flush - executes accumulated operations as intermediate lossless pass
+func (p *chainPipeline) flush() error {
+	if p.stage == stageNone && p.autoRotated {
+		return nil
+	}
+	p.options.NoAutoRotate = p.autoRotated
+	p.options.StripMetadata = true
+	p.options.Type = bimg.PNG
+	var out, err = bimg.NewImage(p.buffer).Process(p.options)
+	if err != nil {
+		return err
+	}
+	p.buffer = out
+	p.options = bimg.Options{}
+	p.stage = stageNone
+	p.autoRotated = true
+	return nil
+}
+
+// enter - moves pipeline to given stage, flushing current pass if needed
+func (p *chainPipeline) enter(stage int) error {
+	if stage <= p.stage {
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+	p.stage = stage
+	return nil
+}
+
+// area - returns top left point of width x height area placed by gravity
+func (p *chainPipeline) area(width, height int, gravity string) (int, int) {
+	switch gravity {
+	case "north":
+		return (p.width - width) / 2, 0
+	case "south":
+		return (p.width - width) / 2, p.height - height
+	case "east":
+		return p.width - width, (p.height - height) / 2
+	case "west":
+		return 0, (p.height - height) / 2
+	}
+	return (p.width - width) / 2, (p.height - height) / 2
+}
+
+func (p *chainPipeline) resize(op storage.Operation) error {
+	switch op.Mode {
+	case storage.ResizeFill:
+		// fill uses crop of bimg which conflicts with extract area, so it occupies both stages
+		if err := p.enter(stageResize); err != nil {
+			return err
+		}
+		p.stage = stageExtract
+		p.options.Width, p.options.Height = op.Width, op.Height
+		p.options.Crop = true
+		p.options.Enlarge = true
+		p.options.Gravity = gravities[op.Gravity]
+		p.width, p.height = op.Width, op.Height
+		return nil
+	case storage.ResizeForce:
+		if err := p.enter(stageResize); err != nil {
+			return err
+		}
+		p.options.Width, p.options.Height = op.Width, op.Height
+		p.options.Force = true
+		p.width, p.height = op.Width, op.Height
+		return nil
+	}
+
+	var scale = math.Inf(1)
+	if op.Width > 0 {
+		scale = float64(op.Width) / float64(p.width)
+	}
+	if op.Height > 0 {
+		scale = math.Min(scale, float64(op.Height)/float64(p.height))
+	}
+	if scale >= 1 {
+		// fit never enlarges image
+		return nil
+	}
+	if err := p.enter(stageResize); err != nil {
+		return err
+	}
+	p.width = int(math.Max(1, math.Floor(float64(p.width)*scale+0.5)))
+	p.height = int(math.Max(1, math.Floor(float64(p.height)*scale+0.5)))
+	p.options.Width, p.options.Height = p.width, p.height
+	p.options.Force = true
+	return nil
+}
+
+func (p *chainPipeline) crop(op storage.Operation) error {
+	var width, height, left, top = op.Width, op.Height, op.X, op.Y
+	if op.Aspect != "" {
+		var aw, ah, _ = storage.ParseAspect(op.Aspect)
+		width, height = p.width, p.width*ah/aw
+		if height > p.height {
+			width, height = p.height*aw/ah, p.height
+		}
+		left, top = p.area(width, height, op.Gravity)
+	} else if op.Gravity != "" {
+		left, top = p.area(width, height, op.Gravity)
+	}
+
+	if left < 0 || top < 0 || left+width > p.width || top+height > p.height {
+		return fmt.Errorf("crop area %vx%v at (%v, %v) is out of image %vx%v", width, height, left, top, p.width, p.height)
+	}
+	if err := p.enter(stageExtract); err != nil {
+		return err
+	}
+	p.options.Left, p.options.Top = left, top
+	p.options.AreaWidth, p.options.AreaHeight = width, height
+	p.width, p.height = width, height
+	return nil
+}
+
+// Derive - applies edit operations to stored copy of image, result has high quality
+// to be used as uploaded image, png stays png so alpha channel is not lost
+func Derive(buffer ImageBuffer, ops []storage.Operation) (ImageBuffer, error) {
+	if bimg.DetermineImageType(buffer) == bimg.PNG {
+		ops = append(ops, storage.Operation{Op: storage.OpFormat, Format: "png"})
+	}
+	return Chain(buffer, ops, normalizedQuality)
+}
+
+// Chain - applies operations in given order, executing them in as few libvips passes as possible
+func Chain(buffer ImageBuffer, ops []storage.Operation, quality int) (ImageBuffer, error) {
+	var meta, err = bimg.Metadata(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	var p = &chainPipeline{buffer: buffer, width: meta.Size.Width, height: meta.Size.Height}
+	if meta.Orientation >= 5 && meta.Orientation <= 8 {
+		p.width, p.height = p.height, p.width
+	}
+
+	var interpretation bimg.Interpretation
+	var output = bimg.JPEG
+
+	for _, op := range ops {
+		switch op.Op {
+		case storage.OpResize:
+			err = p.resize(op)
+		case storage.OpCrop:
+			err = p.crop(op)
+		case storage.OpRotate:
+			// explicit rotation disables exif based one, so it is applied by separate pass
+			if !p.autoRotated && meta.Orientation > 1 {
+				err = p.flush()
+			}
+			if err == nil {
+				err = p.enter(stageRotate)
+			}
+			p.options.Rotate = bimg.Angle(op.Angle)
+			if op.Angle != 180 {
+				p.width, p.height = p.height, p.width
+			}
+		case storage.OpFlip:
+			err = p.enter(stageFlip)
+			if op.Direction == "vertical" {
+				p.options.Flop = true
+			} else {
+				p.options.Flip = true
+			}
+		case storage.OpBlur:
+			err = p.enter(stageBlur)
+			p.options.GaussianBlur = bimg.GaussianBlur{Sigma: op.Sigma}
+		case storage.OpSharpen:
+			err = p.enter(stageSharpen)
+			var radius = op.Radius
+			if radius == 0 {
+				radius = 1
+			}
+			// libvips defaults for the rest of parameters
+			p.options.Sharpen = bimg.Sharpen{Radius: radius, X1: 2, Y2: 10, Y3: 20, M1: 0, M2: 3}
+		case storage.OpGrayscale:
+			interpretation = bimg.InterpretationBW
+		case storage.OpFormat:
+			output = formats[op.Format]
+		case storage.OpQuality:
+			quality = op.Quality
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.options.NoAutoRotate = p.autoRotated
+	p.options.StripMetadata = true
+	p.options.Interlace = true // adds progressive jpeg support
+	p.options.Quality = quality
+	p.options.Type = output
+	p.options.Interpretation = interpretation
+	return bimg.NewImage(p.buffer).Process(p.options)
+}
@@ -0,0 +1,52 @@
+package transformations
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/imagemeta"
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// normalization pass is followed by transformations, so it keeps as much quality as possible
+const normalizedQuality = 95
+
+// ColourSpace - returns libvips interpretation of image and description of its ICC profile
+func ColourSpace(buffer ImageBuffer) (space, profile string, err error) {
+	meta, err := bimg.Metadata(buffer)
+	if err != nil {
+		return "", "", err
+	}
+	if meta.Profile {
+		profile = imagemeta.ProfileDescription(imagemeta.ICCProfile(buffer))
+	}
+	return meta.Space, profile, nil
+}
+
+// NormalizeColour - converts image with embedded non sRGB profile or CMYK image to sRGB,
+// otherwise StripMetadata drops profile and colors of transformed image are distorted.
+// Orientation and other metadata are kept for following transformations.
+func NormalizeColour(buffer ImageBuffer, sRGBProfile string) (ImageBuffer, error) {
+	meta, err := bimg.Metadata(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	var isCMYK = meta.Space == "cmyk"
+	if !isCMYK && (!meta.Profile || imagemeta.IsSRGBProfile(imagemeta.ICCProfile(buffer))) {
+		return buffer, nil
+	}
+
+	var options = bimg.Options{
+		NoAutoRotate:   true,
+		Quality:        normalizedQuality,
+		OutputICC:      sRGBProfile,
+		Interpretation: bimg.InterpretationSRGB,
+	}
+	if isCMYK && meta.Profile {
+		// bimg converts color space before applying ICC transform,
+		// so CMYK is kept to let embedded profile do the conversion
+		options.Interpretation = bimg.InterpretationCMYK
+	}
+	if meta.Type != "jpeg" && meta.Type != "png" && meta.Type != "webp" {
+		options.Type = bimg.JPEG
+	}
+	return bimg.NewImage(buffer).Process(options)
+}
@@ -0,0 +1,167 @@
+package transformations
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+static int
+has_operation(const char *name) {
+	return vips_type_find("VipsOperation", name) != 0;
+}
+
+// can_decode - reports whether image in buffer can be loaded and its pixels decoded
+static int
+can_decode(void *buf, size_t len) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		vips_error_clear();
+		return 0;
+	}
+	double avg;
+	int err = vips_avg(image, &avg, NULL);
+	g_object_unref(image);
+	if (err) {
+		vips_error_clear();
+	}
+	return !err;
+}
+
+// convert_buffer - loads image of any format known to libvips and saves it as png if it has alpha, otherwise as jpeg
+static int
+convert_buffer(void *buf, size_t len, int quality, void **out, size_t *out_len) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		return -1;
+	}
+	// heif loader applies rotation itself, so orientation is reset to avoid second rotation,
+	// images of other loaders keep it and are rotated by transformations
+	const char *loader;
+	if (vips_image_get_typeof(image, "vips-loader") &&
+		!vips_image_get_string(image, "vips-loader", &loader) && vips_isprefix("heifload", loader)) {
+		vips_image_set_int(image, "orientation", 1);
+	}
+
+	int err;
+	if (vips_image_hasalpha(image)) {
+		err = vips_pngsave_buffer(image, out, out_len, NULL);
+	} else {
+		err = vips_jpegsave_buffer(image, out, out_len, "Q", quality, NULL);
+	}
+	g_object_unref(image);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// bimgFormats - formats which bimg can load, other formats are loaded by libvips directly
+var bimgFormats = map[string]bimg.ImageType{
+	"jpeg": bimg.JPEG,
+	"png":  bimg.PNG,
+	"webp": bimg.WEBP,
+	"gif":  bimg.GIF,
+	"tiff": bimg.TIFF,
+}
+
+// foreignLoaders - libvips operations which load formats unknown to bimg
+var foreignLoaders = map[string]string{
+	"heif": "heifload_buffer",
+	"avif": "heifload_buffer",
+}
+
+// foreignSamples - images of formats whose loader can exist without decoder,
+// e.g. libheif is often built without AV1 decoder, such format is supported only if its sample is decoded
+var foreignSamples = map[string][]byte{
+	"avif": avifSample,
+}
+
+var (
+	inputFormats     []string
+	inputFormatsOnce sync.Once
+)
+
+// InputFormats - returns formats which can be loaded by linked libvips
+func InputFormats() []string {
+	inputFormatsOnce.Do(func() {
+		for format, imageType := range bimgFormats {
+			if bimg.IsTypeSupported(imageType) {
+				inputFormats = append(inputFormats, format)
+			}
+		}
+		for format, loader := range foreignLoaders {
+			if HasOperation(loader) && canDecodeSample(format) {
+				inputFormats = append(inputFormats, format)
+			}
+		}
+		sort.Strings(inputFormats)
+	})
+	return inputFormats
+}
+
+// HasOperation - reports whether linked libvips has operation,
+// e.g. "icc_transform" exists only if libvips is built with lcms2
+func HasOperation(name string) bool {
+	var cName = C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	return C.has_operation(cName) != 0
+}
+
+// canDecodeSample - reports whether sample of format is decoded by linked libvips, formats without sample are decoded
+func canDecodeSample(format string) bool {
+	var sample, ok = foreignSamples[format]
+	if !ok {
+		return true
+	}
+	return C.can_decode(unsafe.Pointer(&sample[0]), C.size_t(len(sample))) != 0
+}
+
+// IsInputSupported - reports whether images of given format can be transformed
+func IsInputSupported(format string) bool {
+	for _, f := range InputFormats() {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputFormats - returns formats which transformations can produce with linked libvips
+func OutputFormats() []string {
+	var formats []string
+	for _, format := range []string{"jpeg", "png", "webp"} {
+		if bimg.IsTypeSupportedSave(bimgFormats[format]) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// ToTransformable - converts image which is not jpeg, png or webp to jpeg,
+// or to png if it has alpha channel, so every transformation can save it in its own format
+func ToTransformable(buffer ImageBuffer) (ImageBuffer, error) {
+	switch bimg.DetermineImageType(buffer) {
+	case bimg.JPEG, bimg.PNG, bimg.WEBP:
+		return buffer, nil
+	}
+	if len(buffer) == 0 {
+		return nil, errors.New("image buffer is empty")
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.convert_buffer(unsafe.Pointer(&buffer[0]), C.size_t(len(buffer)), C.int(normalizedQuality), &out, &length) != 0 {
+		var err = errors.New(C.GoString(C.vips_error_buffer()))
+		C.vips_error_clear()
+		return nil, err
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
@@ -0,0 +1,30 @@
+package transformations
+
+import (
+	"github.com/KazanExpress/louis/internal/pkg/imagemeta"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// PreserveMetadata - writes copyright and color profile of source image into transformed one
+// if transformation asks for it, only jpeg output can hold preserved metadata
+func PreserveMetadata(source, result ImageBuffer, trans *storage.Transformation) (ImageBuffer, error) {
+	if !trans.KeepCopyright && !trans.KeepICC {
+		return result, nil
+	}
+	if bimg.DetermineImageType(result) != bimg.JPEG {
+		return result, nil
+	}
+
+	var preserved imagemeta.Preserved
+	if trans.KeepCopyright {
+		// broken source metadata should not break transformation
+		if m, err := imagemeta.Extract(source); err == nil && m != nil {
+			preserved.Artist, preserved.Copyright = m.Artist, m.Copyright
+		}
+	}
+	if trans.KeepICC {
+		preserved.ICC = imagemeta.ICCProfile(source)
+	}
+	return imagemeta.Embed(result, &preserved)
+}
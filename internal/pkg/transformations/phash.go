@@ -0,0 +1,20 @@
+package transformations
+
+import (
+	"bytes"
+	"github.com/KazanExpress/louis/internal/pkg/phash"
+	"image/jpeg"
+)
+
+// PerceptualHash - calculates dHash of image, thumbnail of placeholder size is enough for 9x8 grid
+func PerceptualHash(buffer ImageBuffer) (uint64, error) {
+	var thumbnail, err = Fit(buffer, placeholderThumbnailSide, 90)
+	if err != nil {
+		return 0, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		return 0, err
+	}
+	return phash.DHash(img), nil
+}
@@ -0,0 +1,47 @@
+package transformations
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/KazanExpress/louis/internal/pkg/placeholder"
+	"image/jpeg"
+)
+
+const (
+	// blurhash and dominant color are calculated on thumbnail of such side
+	placeholderThumbnailSide = 32
+	previewSide              = 16
+	previewQuality           = 40
+)
+
+// MakePlaceholder - calculates blurhash, dominant color and tiny inline preview of image
+func MakePlaceholder(buffer ImageBuffer) (*placeholder.Placeholder, error) {
+	var thumbnail, err = Fit(buffer, placeholderThumbnailSide, 90)
+	if err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		return nil, err
+	}
+
+	var xComponents, yComponents = 4, 3
+	if img.Bounds().Dy() > img.Bounds().Dx() {
+		xComponents, yComponents = 3, 4
+	}
+	hash, err := placeholder.BlurHash(img, xComponents, yComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	preview, err := Fit(buffer, previewSide, previewQuality)
+	if err != nil {
+		return nil, err
+	}
+
+	return &placeholder.Placeholder{
+		BlurHash:      hash,
+		DominantColor: placeholder.DominantColor(img),
+		Preview:       "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(preview),
+	}, nil
+}
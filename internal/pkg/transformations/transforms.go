@@ -106,6 +106,10 @@ func MakeProgressive(image ImageBuffer) (ImageBuffer, error) {
 type TransformParams struct {
 	Image      ImageBuffer
 	CropSquare *utils.Square
+	// Source - uploaded animated image, Image holds only its first frame, "real" copy keeps Source
+	Source ImageBuffer
+	// Animated - if animation of Source can be kept by transformations
+	Animated bool
 }
 
 // ImageTransformer - is shortcut type
@@ -115,17 +119,29 @@ type ImageTransformer = func(params TransformParams, trans *storage.Transformati
 func GetTransformsMappings() map[string]ImageTransformer {
 	return map[string]ImageTransformer{
 		"fill": func(params TransformParams, tran *storage.Transformation) (ImageBuffer, error) {
+			if params.Animated && tran.KeepAnimation {
+				return ResizeAnimated(params.Source, tran.Width, tran.Height, true, AnimationFormat(tran), tran.Quality)
+			}
 			return Fill(params.Image, tran.Width, tran.Height, tran.Quality)
 		},
 		"fit": func(params TransformParams, tran *storage.Transformation) (ImageBuffer, error) {
+			if params.Animated && tran.KeepAnimation {
+				return ResizeAnimated(params.Source, tran.Width, tran.Width, false, AnimationFormat(tran), tran.Quality)
+			}
 			return Fit(params.Image, tran.Width, tran.Quality)
 		},
 		"real": func(params TransformParams, trans *storage.Transformation) (ImageBuffer, error) {
+			if params.Source != nil {
+				return params.Source, nil
+			}
 			return params.Image, nil
 		},
 		"original": func(params TransformParams, trans *storage.Transformation) (ImageBuffer, error) {
 			return Compress(params.Image, trans.Quality)
 		},
+		"poster": func(params TransformParams, trans *storage.Transformation) (ImageBuffer, error) {
+			return Poster(params.Image, trans.Quality)
+		},
 		"crop": func(params TransformParams, trans *storage.Transformation) (ImageBuffer, error) {
 			if params.CropSquare == nil {
 				return nil, fmt.Errorf("crop requires crop square")
@@ -134,5 +150,22 @@ func GetTransformsMappings() map[string]ImageTransformer {
 			var height = params.CropSquare.BottomRightPoint.Y - params.CropSquare.TopLeftPoint.Y
 			return Crop(params.Image, params.CropSquare.TopLeftPoint.X, params.CropSquare.TopLeftPoint.Y, width, height, trans.Quality)
 		},
+		storage.ChainTransformType: func(params TransformParams, trans *storage.Transformation) (ImageBuffer, error) {
+			return Chain(params.Image, trans.Operations, trans.Quality)
+		},
+	}
+}
+
+// Validate - checks that all transformations are known and correctly described
+func Validate(list []storage.Transformation) error {
+	var mappings = GetTransformsMappings()
+	for i := range list {
+		if _, exists := mappings[list[i].Type]; !exists {
+			return fmt.Errorf("transformation %q has unknown type %q", list[i].Name, list[i].Type)
+		}
+		if err := list[i].Validate(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -2,6 +2,7 @@ package transformations
 
 import (
 	"fmt"
+	"github.com/KazanExpress/louis/internal/pkg/storage"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/h2non/bimg.v1"
 	"io/ioutil"
@@ -85,6 +86,78 @@ func testFill(pictureBytes []byte) func(*testing.T) {
 	}
 }
 
+func testChain(pictureBytes []byte) func(*testing.T) {
+
+	return func(t *testing.T) {
+
+		// assert.
+		assert := assert.New(t)
+
+		newPictureBytes, err := Chain(pictureBytes, []storage.Operation{
+			{Op: storage.OpCrop, Aspect: "1:1"},
+			{Op: storage.OpResize, Width: 120, Height: 120},
+			{Op: storage.OpSharpen},
+			{Op: storage.OpFlip},
+			{Op: storage.OpFormat, Format: "webp"},
+		}, 80)
+		assert.NoError(err)
+
+		newImg := bimg.NewImage(newPictureBytes)
+		assert.Equal("webp", newImg.Type())
+
+		assert.Condition(func() bool {
+			isz, err := newImg.Size()
+			assert.NoError(err)
+
+			return isz.Width == 120 && isz.Height == 120
+		})
+	}
+}
+
+func testNormalizeColour(pictureBytes []byte) func(*testing.T) {
+
+	return func(t *testing.T) {
+
+		assert := assert.New(t)
+
+		cmyk, err := bimg.NewImage(pictureBytes).Process(bimg.Options{
+			Interpretation: bimg.InterpretationCMYK,
+			Type:           bimg.JPEG,
+		})
+		assert.NoError(err)
+
+		space, _, err := ColourSpace(cmyk)
+		assert.NoError(err)
+		assert.Equal("cmyk", space)
+
+		normalized, err := NormalizeColour(cmyk, "srgb")
+		assert.NoError(err)
+
+		space, _, err = ColourSpace(normalized)
+		assert.NoError(err)
+		assert.Equal("srgb", space)
+	}
+}
+
+func testToTransformable(pictureBytes []byte) func(*testing.T) {
+
+	return func(t *testing.T) {
+
+		assert := assert.New(t)
+
+		tiff, err := bimg.NewImage(pictureBytes).Convert(bimg.TIFF)
+		assert.NoError(err)
+
+		converted, err := ToTransformable(tiff)
+		assert.NoError(err)
+		assert.Equal(bimg.JPEG, bimg.DetermineImageType(converted))
+
+		same, err := ToTransformable(pictureBytes)
+		assert.NoError(err)
+		assert.Equal(pictureBytes, same)
+	}
+}
+
 func TestFit(t *testing.T) {
 	const picsDir = "../../../test/data/pics"
 	files, err := ioutil.ReadDir(picsDir)
@@ -122,3 +195,57 @@ func TestCrop(t *testing.T) {
 		t.Run(fmt.Sprintf("Test Crop on image %v", imgpath), testCrop(picture))
 	}
 }
+
+func TestChain(t *testing.T) {
+	const picsDir = "../../../test/data/pics"
+	files, err := ioutil.ReadDir(picsDir)
+	assert.NoError(t, err)
+
+	for _, file := range files {
+		imgpath := path.Join(picsDir, file.Name())
+		picture, err := bimg.Read(imgpath)
+		assert.NoError(t, err)
+		t.Run(fmt.Sprintf("Test Chain on image %v", imgpath), testChain(picture))
+	}
+}
+
+func TestNormalizeColour(t *testing.T) {
+	const picsDir = "../../../test/data/pics"
+	files, err := ioutil.ReadDir(picsDir)
+	assert.NoError(t, err)
+
+	for _, file := range files {
+		imgpath := path.Join(picsDir, file.Name())
+		picture, err := bimg.Read(imgpath)
+		assert.NoError(t, err)
+		t.Run(fmt.Sprintf("Test NormalizeColour on image %v", imgpath), testNormalizeColour(picture))
+	}
+}
+
+func TestToTransformable(t *testing.T) {
+	const picsDir = "../../../test/data/pics"
+	files, err := ioutil.ReadDir(picsDir)
+	assert.NoError(t, err)
+
+	for _, file := range files {
+		imgpath := path.Join(picsDir, file.Name())
+		picture, err := bimg.Read(imgpath)
+		assert.NoError(t, err)
+		t.Run(fmt.Sprintf("Test ToTransformable on image %v", imgpath), testToTransformable(picture))
+	}
+}
+
+func TestAvifInput(t *testing.T) {
+	if !IsInputSupported("avif") {
+		t.Skip("linked libvips can not decode avif")
+	}
+	assert := assert.New(t)
+
+	image, err := ToTransformable(avifSample)
+	assert.NoError(err)
+	assert.Equal(bimg.JPEG, bimg.DetermineImageType(image))
+	size, err := bimg.NewImage(image).Size()
+	assert.NoError(err)
+	assert.Equal(8, size.Width)
+	assert.Equal(8, size.Height)
+}
@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/namsral/flag"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -15,6 +18,8 @@ type Config struct {
 	CleanupPoolConcurrency uint   `envconfig:"CLEANUP_POOL_CONCURRENCY" default:"10"`
 	// In minutes; TODO: -> 1m
 	CleanUpDelay int `envconfig:"CLEANUP_DELAY" default:"1"`
+	// VersionRetention - how long objects of replaced image version are kept
+	VersionRetention time.Duration `envconfig:"VERSION_RETENTION" default:"24h"`
 
 	PostgresUser     string `envconfig:"POSTGRES_USER" default:"postgres"`
 	PostgresPassword string `envconfig:"POSTGRES_PASSWORD" default:""`
@@ -26,6 +31,52 @@ type Config struct {
 	CORSAllowHeaders string `envconfig:"CORS_ALLOW_HEADERS" default:"Authorization,Content-Type,Access-Content-Allow-Origin"`
 	// MaxImageSize maximum image size in bytes, default is 5MB
 	MaxImageSize int64 `envconfig:"MAX_IMAGE_SIZE" default:"5242880"`
+	// MaxBatchFiles - maximum number of files in one upload request, they are handled by BatchConcurrency goroutines
+	MaxBatchFiles    int `envconfig:"MAX_BATCH_FILES" default:"20"`
+	BatchConcurrency int `envconfig:"BATCH_CONCURRENCY" default:"4"`
+	// Limits of image dimensions read from header before decoding, 0 means no limit
+	MaxImagePixels int64 `envconfig:"MAX_IMAGE_PIXELS" default:"50000000"`
+	MaxImageWidth  int   `envconfig:"MAX_IMAGE_WIDTH" default:"0"`
+	MaxImageHeight int   `envconfig:"MAX_IMAGE_HEIGHT" default:"0"`
+	MinImageWidth  int   `envconfig:"MIN_IMAGE_WIDTH" default:"0"`
+	MinImageHeight int   `envconfig:"MIN_IMAGE_HEIGHT" default:"0"`
+	// KeepGPSMetadata - store GPS location of uploaded images, it is removed by default for privacy
+	KeepGPSMetadata bool `envconfig:"KEEP_GPS_METADATA" default:"false"`
+	// SRGBProfile - path to sRGB ICC profile which images are converted to, "srgb" is built in profile of libvips 8.11+;
+	// empty value disables color normalization
+	SRGBProfile string `envconfig:"SRGB_PROFILE" default:"srgb"`
+
+	// Import of images from remote URLs, hosts are matched exactly or by suffix if entry starts with dot,
+	// empty list disables import and "*" allows any host
+	ImportAllowedHosts []string      `envconfig:"IMPORT_ALLOWED_HOSTS"`
+	ImportTimeout      time.Duration `envconfig:"IMPORT_TIMEOUT" default:"30s"`
+	ImportMaxRedirects int           `envconfig:"IMPORT_MAX_REDIRECTS" default:"3"`
+	ImportMaxItems     int           `envconfig:"IMPORT_MAX_ITEMS" default:"100"`
+	// ImportAllowPrivate - allow import from loopback, private and link-local addresses, e.g. cloud metadata endpoint
+	ImportAllowPrivate bool `envconfig:"IMPORT_ALLOW_PRIVATE" default:"false"`
+
+	// TusExpiration - how long incomplete resumable upload is kept
+	TusExpiration time.Duration `envconfig:"TUS_EXPIRATION" default:"24h"`
+
+	// DirectUploadExpiration - how long presigned URL of direct upload is valid and staged object is kept
+	DirectUploadExpiration time.Duration `envconfig:"DIRECT_UPLOAD_EXPIRATION" default:"1h"`
+	// StagingPrefix - prefix of keys of objects uploaded directly to bucket
+	StagingPrefix string `envconfig:"STAGING_PREFIX" default:"staging/"`
+
+	// CacheControl - Cache-Control header of objects of transformations which do not set it
+	CacheControl string `envconfig:"CACHE_CONTROL" default:""`
+
+	// KeyLayout - folder of objects of new images, see keylayout.Layout
+	KeyLayout string `envconfig:"KEY_LAYOUT" default:"{key}"`
+
+	// PublicURLTemplate - template of returned and stored image URLs, S3 locations are used if it is empty,
+	// CDNBaseURL replaces {cdn} placeholder unless image has tag listed in CDNTagHosts as "tag=url"
+	PublicURLTemplate string   `envconfig:"PUBLIC_URL_TEMPLATE" default:""`
+	CDNBaseURL        string   `envconfig:"CDN_BASE_URL" default:""`
+	CDNTagHosts       []string `envconfig:"CDN_TAG_HOSTS"`
+
+	// PrivateURLExpiration - how long presigned URLs of private images are valid
+	PrivateURLExpiration time.Duration `envconfig:"PRIVATE_URL_EXPIRATION" default:"1h"`
 
 	ThrottlerQueueLength int64  `envconfig:"THROTTLER_QUEUE_LENGTH" default:"10"`
 	ThrottlerTimeoutStr  string `envconfig:"THROTTLER_TIMEOUT" default:"15s"`
@@ -45,6 +96,73 @@ type Config struct {
 	S3Endpoint        string `split_words:"true"`
 	S3AccessKeyID     string `split_words:"true"`
 	S3SecretAccessKey string `split_words:"true"`
+	// S3Compat - compatibility profile of S3 provider, see storage.CompatProfiles
+	S3Compat string `envconfig:"S3_COMPAT" default:"vk"`
+
+	// S3Profiles - names of additional storages tags can be routed to, settings of each one
+	// are read from S3_PROFILE_<NAME>_* variables into S3ProfileSettings
+	S3Profiles        []string              `envconfig:"S3_PROFILES"`
+	S3ProfileSettings map[string]*S3Profile `ignored:"true"`
+	// RealStorageClass - storage class of "real" copies unless tag settings choose another one,
+	// empty value means default class of bucket
+	RealStorageClass string `envconfig:"REAL_STORAGE_CLASS" default:""`
+
+	// ReplicaProfile or ReplicaDir - storage profile or directory "real" copies are replicated to,
+	// replication is disabled if both are empty
+	ReplicaProfile string `envconfig:"REPLICA_PROFILE" default:""`
+	ReplicaDir     string `envconfig:"REPLICA_DIR" default:""`
+
+	// SSE - server side encryption of "real" copies, SSEAllVariants applies it to all transformations,
+	// SSEPreviousCustomerKeys - rotated customer keys, they are used to read and re-encrypt existing objects
+	SSE                     string   `envconfig:"SSE" default:""`
+	SSEKMSKeyID             string   `envconfig:"SSE_KMS_KEY_ID" default:""`
+	SSECustomerKey          string   `envconfig:"SSE_CUSTOMER_KEY" default:""`
+	SSEPreviousCustomerKeys []string `envconfig:"SSE_PREVIOUS_CUSTOMER_KEYS"`
+	SSEAllVariants          bool     `envconfig:"SSE_ALL_VARIANTS" default:"false"`
+}
+
+// S3Profile - bucket and credentials of storage profile, empty fields except bucket are taken from main S3 settings
+type S3Profile struct {
+	Bucket          string `split_words:"true"`
+	Region          string `split_words:"true"`
+	Endpoint        string `split_words:"true"`
+	AccessKeyID     string `split_words:"true"`
+	SecretAccessKey string `split_words:"true"`
+	Compat          string
+}
+
+var profileNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// loadS3Profiles - reads settings of S3Profiles, e.g. bucket of profile "archive" is S3_PROFILE_ARCHIVE_BUCKET
+func (c *Config) loadS3Profiles() error {
+	c.S3ProfileSettings = make(map[string]*S3Profile, len(c.S3Profiles))
+	for _, name := range c.S3Profiles {
+		if !profileNameRe.MatchString(name) {
+			return fmt.Errorf("storage profile name %q should contain only lowercase letters, digits and underscores", name)
+		}
+		var prefix = "S3_PROFILE_" + strings.ToUpper(name)
+		var profile = &S3Profile{}
+		if err := envconfig.Process(prefix, profile); err != nil {
+			return err
+		}
+		if profile.Bucket == "" {
+			return fmt.Errorf("storage profile %q requires %v_BUCKET", name, prefix)
+		}
+		if profile.Region == "" {
+			profile.Region = c.S3Region
+		}
+		if profile.Endpoint == "" {
+			profile.Endpoint = c.S3Endpoint
+		}
+		if profile.Compat == "" {
+			profile.Compat = c.S3Compat
+		}
+		if profile.AccessKeyID == "" && profile.SecretAccessKey == "" {
+			profile.AccessKeyID, profile.SecretAccessKey = c.S3AccessKeyID, c.S3SecretAccessKey
+		}
+		c.S3ProfileSettings[name] = profile
+	}
+	return nil
 }
 
 // App - application configs
@@ -84,5 +202,9 @@ func InitConfigFrom(envPath string) *Config {
 		panic(err)
 	}
 
+	if err = App.loadS3Profiles(); err != nil {
+		panic(err)
+	}
+
 	return App
 }